@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// appendRange is the part of a file that was appended since it was last seen
+// Start is inclusive and end is exclusive
+type appendRange struct {
+	start int64
+	end   int64
+}
+
+// appended holds the ranges appended to files since the last run when
+// -append-only is set
+// It's only used by the main loop
+var appended = make(map[string]appendRange)
+
+// noteAppend records that a file grew from one size to another, extending
+// the range already recorded if it grew more than once before a run
+func noteAppend(path string, from, to int64) {
+	r, ok := appended[path]
+	if !ok {
+		r.start = from
+	}
+	r.end = to
+
+	appended[path] = r
+}
+
+// appendedFile is a file along with the range appended to it
+type appendedFile struct {
+	path string
+	appendRange
+}
+
+// appendedFiles returns the ranges appended to the given files, so that a
+// run can use them after the main loop has moved on
+func appendedFiles(files []string) []appendedFile {
+	var grown []appendedFile
+	for _, path := range files {
+		if r, ok := appended[path]; ok {
+			grown = append(grown, appendedFile{path: path, appendRange: r})
+		}
+	}
+
+	return grown
+}
+
+// appendedEnv returns the WATCH_APPENDED environment variable for the given
+// files, which has one "start end path" line for each file that grew, with
+// the paths relative to dir
+func appendedEnv(dir string, grown []appendedFile) []string {
+	if len(grown) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, f := range grown {
+		lines = append(lines, fmt.Sprintf("%v %v %v", f.start, f.end, relativePath(dir, f.path)))
+	}
+
+	return []string{"WATCH_APPENDED=" + strings.Join(lines, "\n")}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// checkCommands returns an error for each command whose program can't be
+// found, parsing the commands the same way as when they're run
+//
+// Programs given as a path, such as ./app, may be built by an earlier command
+// so only bare names are looked up on the PATH, and with -shell the command
+// strings are left for the shell to make sense of
+func checkCommands(runners []*runner) []error {
+	if opts.shell || (opts.remote != "" && opts.remoteExec) {
+		return nil
+	}
+
+	var errs []error
+	for _, r := range runners {
+		for _, cmdStr := range r.cmds {
+			_, rest := cutCmdEnv(cmdStr)
+			_, fields := cutStdin(split(rest))
+			if len(fields) == 0 {
+				errs = append(errs, fmt.Errorf("no command given in %q", cmdStr))
+
+				continue
+			}
+
+			program, _, _ := command(fields[0], fields[1:]...)
+			if hasPlaceholders(program) || strings.ContainsAny(program, `/\`) {
+				continue
+			}
+
+			if _, err := exec.LookPath(program); err != nil {
+				errs = append(errs, fmt.Errorf("%v: %w", cmdStr, err))
+			}
+		}
+	}
+
+	return errs
+}
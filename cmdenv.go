@@ -0,0 +1,95 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// cmdEnv is the directory and extra environment a single command runs with,
+// given by assignments at the start of the command string, for example:
+// "dir=./frontend env=NODE_ENV=dev npm run build"
+type cmdEnv struct {
+	dir string
+	env []string
+}
+
+// cutCmdEnv removes any leading dir= and env= assignments from a command
+// string before it's split or given to the shell
+// Values can't contain spaces, and env= can be given more than once
+func cutCmdEnv(cmdStr string) (cmdEnv, string) {
+	var ce cmdEnv
+	for {
+		rest := strings.TrimLeftFunc(cmdStr, unicode.IsSpace)
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end < 0 {
+			end = len(rest)
+		}
+		word := rest[:end]
+
+		if dir, ok := strings.CutPrefix(word, "dir="); ok && dir != "" {
+			ce.dir = dir
+		} else if env, ok := strings.CutPrefix(word, "env="); ok && strings.IndexByte(env, '=') > 0 {
+			ce.env = append(ce.env, env)
+		} else {
+			return ce, cmdStr
+		}
+
+		cmdStr = rest[len(word):]
+	}
+}
+
+// workdir returns the directory to run the command in, which is relative to
+// -workdir if that's set
+func (ce cmdEnv) workdir() string {
+	switch {
+	case ce.dir == "":
+		return opts.workdir
+
+	case filepath.IsAbs(ce.dir):
+		return ce.dir
+
+	default:
+		return filepath.Join(opts.workdir, ce.dir)
+	}
+}
+
+// relativePath returns a path from the watch root relative to dir instead,
+// so that a command running in another directory can still find the file
+// Remote paths are left as they are
+func relativePath(dir, path string) string {
+	if dir == "" || remote != nil {
+		return path
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return path
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil {
+		return absPath
+	}
+
+	return rel
+}
+
+// relativePaths returns the paths relative to dir
+func relativePaths(dir string, paths []string) []string {
+	if dir == "" || remote != nil {
+		return paths
+	}
+
+	rel := make([]string, len(paths))
+	for i, path := range paths {
+		rel[i] = relativePath(dir, path)
+	}
+
+	return rel
+}
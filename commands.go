@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// generateCommands runs the -commands-from-cmd program and returns the lines
+// it prints as commands, ignoring blank lines
+func generateCommands(cmdStr string) ([]string, error) {
+	fields := split(cmdStr)
+	if len(fields) == 0 {
+		return nil, errors.New("no command given")
+	}
+
+	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var cmds []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			cmds = append(cmds, line)
+		}
+	}
+
+	return cmds, nil
+}
+
+// loadRunners creates the runners for the commands given on the command line
+// followed by any generated commands
+func loadRunners(args []string) ([]*runner, error) {
+	if opts.commandsFromCmd == "" {
+		return newRunners(args), nil
+	}
+
+	cmds, err := generateCommands(opts.commandsFromCmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRunners(slices.Concat(args, cmds)), nil
+}
+
+// refreshCommands runs the generator again and replaces the runners with the
+// new commands, keeping the old ones if the generator fails
+func refreshCommands(args []string) {
+	r, err := loadRunners(args)
+	if err != nil {
+		logf(levelError, "watch commands-from-cmd error: %v", err)
+
+		return
+	}
+
+	killAll(runners)
+
+	runnersMu.Lock()
+	runners = r
+	runnersMu.Unlock()
+
+	logf(levelDebug, "watch: refreshed the commands")
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultConfig is the config file read from the current directory when
+// -config isn't given
+const defaultConfig = ".watchrc"
+
+// config is the contents of a config file
+//
+// Settings use the same names as the flags and are kept in the order they
+// were read so that flags that can be repeated, such as -require-all, are
+// set in the same order as on the command line
+type config struct {
+	settings []setting
+	commands []string
+}
+
+type setting struct {
+	name  string
+	value string
+}
+
+// readConfig reads a config file, which is either a JSON object or lines of
+// "name = value", where blank lines and lines starting with # are ignored
+//
+// Commands are given with a "command" line for each one, or as a "commands"
+// array in JSON, and either name works in either format
+func readConfig(path string) (config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return config{}, err
+	}
+
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '{' {
+		return parseJSONConfig(trimmed)
+	}
+
+	var cfg config
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return config{}, fmt.Errorf("line %v: expected name = value", n)
+		}
+
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+
+		// Quotes are only needed to keep leading or trailing spaces
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+
+		if isCommand(name) {
+			cfg.commands = append(cfg.commands, value)
+		} else {
+			cfg.settings = append(cfg.settings, setting{name: name, value: value})
+		}
+	}
+
+	return cfg, sc.Err()
+}
+
+func parseJSONConfig(b []byte) (config, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var values map[string]any
+	if err := dec.Decode(&values); err != nil {
+		return config{}, err
+	}
+
+	var cfg config
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		// Arrays set a flag once for each element
+		value := values[name]
+		elems, ok := value.([]any)
+		if !ok {
+			elems = []any{value}
+		}
+
+		for _, elem := range elems {
+			switch elem.(type) {
+			case string, bool, json.Number:
+			default:
+				return config{}, fmt.Errorf("%v: expected a string, number, or boolean", name)
+			}
+
+			if isCommand(name) {
+				cfg.commands = append(cfg.commands, fmt.Sprint(elem))
+			} else {
+				cfg.settings = append(cfg.settings, setting{name: name, value: fmt.Sprint(elem)})
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// isCommand reports whether a config setting gives a command rather than a
+// flag
+func isCommand(name string) bool {
+	return name == "command" || name == "commands"
+}
+
+// envName returns the environment variable that sets a flag, such as
+// WATCH_SKIP_PATTERNS for -skip-patterns
+func envName(name string) string {
+	return "WATCH_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadConfig sets any flags that weren't given on the command line from the
+// environment and then from the config file, and returns the commands from
+// the config file
+//
+// A missing config file is only an error if it was asked for with -config
+func loadConfig() ([]string, error) {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	fromEnv := make(map[string]bool)
+	var errs []error
+	flag.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || given[f.Name] {
+			return
+		}
+
+		if err := f.Value.Set(value); err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", envName(f.Name), err))
+		}
+
+		fromEnv[f.Name] = true
+	})
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	path := opts.config
+	if path == "" {
+		path = defaultConfig
+	}
+
+	cfg, err := readConfig(path)
+	if errors.Is(err, os.ErrNotExist) && opts.config == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range cfg.settings {
+		if s.name == "config" {
+			return nil, fmt.Errorf("%v: config can't be set in a config file", path)
+		}
+
+		f := flag.Lookup(s.name)
+		if f == nil {
+			return nil, fmt.Errorf("%v: unknown setting %q", path, s.name)
+		}
+
+		if given[s.name] || fromEnv[s.name] {
+			continue
+		}
+
+		if err := f.Value.Set(s.value); err != nil {
+			return nil, fmt.Errorf("%v: %v: %w", path, s.name, err)
+		}
+	}
+
+	// Once a config file has been read it can be reloaded, which picks up
+	// new commands, but other settings need a restart
+	reload = func() error {
+		cfg, err := readConfig(path)
+		if err != nil {
+			return err
+		}
+
+		done := make(chan struct{})
+		actions <- action{kind: actionReload, commands: cfg.commands, done: done}
+		<-done
+
+		return nil
+	}
+
+	return cfg.commands, nil
+}
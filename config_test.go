@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReadConfigCommandNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"lines", "interval = 1s\ncommand = go vet ./...\ncommand = go test ./...\n"},
+		{"lines with commands", "interval = 1s\ncommands = go vet ./...\ncommands = go test ./...\n"},
+		{"JSON", `{"interval": "1s", "commands": ["go vet ./...", "go test ./..."]}`},
+		{"JSON with command", `{"interval": "1s", "command": ["go vet ./...", "go test ./..."]}`},
+	}
+
+	want := []string{"go vet ./...", "go test ./..."}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".watchrc")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := readConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(cfg.commands, want) {
+				t.Errorf("commands = %q, want %q", cfg.commands, want)
+			}
+			if want := []setting{{"interval", "1s"}}; !slices.Equal(cfg.settings, want) {
+				t.Errorf("settings = %v, want %v", cfg.settings, want)
+			}
+		})
+	}
+}
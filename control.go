@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// The control socket speaks JSON-RPC 2.0 with one request or response per
+// line
+//
+// Methods:
+//   - trigger:   run the commands now, returns true
+//   - pause:     stop running commands on changes, returns true
+//   - resume:    start running commands on changes again, returns true
+//   - status:    returns a controlStatus
+//   - reload:    reload the configuration, returns true
+//   - subscribe: returns true, then sends every event as an "event"
+//     notification on the same connection until it's closed
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcServerError    = -32000
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// controlStatus is the result of the status method
+type controlStatus struct {
+	Paused       bool      `json:"paused"`
+	Files        int       `json:"files"`
+	LastRun      time.Time `json:"lastRun"`
+	LastStatus   string    `json:"lastStatus"`
+	LastExitCode int       `json:"lastExitCode"`
+	Running      []string  `json:"running"`
+}
+
+// currentStatus gathers the status from the state shared by the main loop
+// and the runners
+func currentStatus() controlStatus {
+	state.Lock()
+	status := controlStatus{
+		Paused:  state.paused,
+		Files:   state.files,
+		LastRun: state.lastRun,
+	}
+	state.Unlock()
+
+	reportMu.Lock()
+	status.LastStatus = lastStatus
+	status.LastExitCode = lastExitCode
+	reportMu.Unlock()
+
+	runnersMu.Lock()
+	for _, r := range runners {
+		status.Running = append(status.Running, r.runningCommands()...)
+	}
+	runnersMu.Unlock()
+
+	return status
+}
+
+// errNoReload is returned by reload when there is no configuration to reload
+var errNoReload = errors.New("there is no configuration to reload")
+
+// reload is set to reload the configuration once there is one to reload
+var reload func() error
+
+// listenControl starts serving the control socket at path
+// The socket is only accessible to the current user
+func listenControl(path string) error {
+	// Remove a socket left behind by a previous run
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+
+		return err
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				logf(levelError, "watch control socket: %v", err)
+
+				return
+			}
+
+			go serveControl(conn)
+		}
+	}()
+
+	return nil
+}
+
+func serveControl(conn net.Conn) {
+	defer conn.Close()
+
+	var mu sync.Mutex
+	enc := json.NewEncoder(conn)
+	send := func(res rpcResponse) {
+		res.JSONRPC = "2.0"
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		enc.Encode(res)
+	}
+
+	var unsubscribe func()
+	defer func() {
+		if unsubscribe != nil {
+			unsubscribe()
+		}
+	}()
+
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() {
+		var req rpcRequest
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			send(rpcResponse{Error: &rpcError{Code: rpcParseError, Message: err.Error()}})
+
+			continue
+		}
+
+		if req.Method == "subscribe" && unsubscribe == nil {
+			var events <-chan event
+			events, unsubscribe = subscribe()
+
+			go func() {
+				for e := range events {
+					send(rpcResponse{Method: "event", Params: e})
+				}
+			}()
+		}
+
+		result, rpcErr := handleControl(req)
+
+		// Requests without an id are notifications and get no response
+		if len(req.ID) == 0 {
+			continue
+		}
+
+		send(rpcResponse{ID: req.ID, Result: result, Error: rpcErr})
+	}
+}
+
+// handleControl is the reference handler for control requests
+func handleControl(req rpcRequest) (any, *rpcError) {
+	if req.JSONRPC != "2.0" {
+		return nil, &rpcError{Code: rpcInvalidRequest, Message: `jsonrpc must be "2.0"`}
+	}
+
+	// Wait for actions to be handled so that the response reflects them
+	do := func(kind string) {
+		done := make(chan struct{})
+		actions <- action{kind: kind, done: done}
+		<-done
+	}
+
+	switch req.Method {
+	case "trigger":
+		do(actionRun)
+
+	case "pause":
+		do(actionPause)
+
+	case "resume":
+		do(actionResume)
+
+	case "status":
+		return currentStatus(), nil
+
+	case "reload":
+		err := errNoReload
+		if reload != nil {
+			err = reload()
+		}
+		if err != nil {
+			return nil, &rpcError{Code: rpcServerError, Message: err.Error()}
+		}
+
+	case "subscribe":
+		// The subscription itself is set up by the connection
+
+	default:
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method %q", req.Method)}
+	}
+
+	return true, nil
+}
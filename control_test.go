@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeLoop stands in for the main loop, handling actions until the test ends
+// and sending the kind of each one on the returned channel
+func fakeLoop(t *testing.T) chan string {
+	kinds := make(chan string, 10)
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+
+	go func() {
+		for {
+			select {
+			case a := <-actions:
+				kinds <- a.kind
+				if a.done != nil {
+					close(a.done)
+				}
+
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return kinds
+}
+
+func TestHandleControlActions(t *testing.T) {
+	kinds := fakeLoop(t)
+
+	tests := []struct {
+		method string
+		want   string
+	}{
+		{"trigger", actionRun},
+		{"pause", actionPause},
+		{"resume", actionResume},
+	}
+
+	for _, tt := range tests {
+		result, rpcErr := handleControl(rpcRequest{JSONRPC: "2.0", Method: tt.method})
+		if rpcErr != nil {
+			t.Errorf("%v: got error %v", tt.method, rpcErr.Message)
+
+			continue
+		}
+		if result != true {
+			t.Errorf("%v: got result %v, want true", tt.method, result)
+		}
+
+		// The action has been handled by the time the response is sent
+		select {
+		case got := <-kinds:
+			if got != tt.want {
+				t.Errorf("%v: sent a %q action, want %q", tt.method, got, tt.want)
+			}
+
+		default:
+			t.Errorf("%v: responded before the action was handled", tt.method)
+		}
+	}
+}
+
+func TestHandleControlStatus(t *testing.T) {
+	state.Lock()
+	state.paused = true
+	state.files = 42
+	state.Unlock()
+	t.Cleanup(func() {
+		state.Lock()
+		state.paused = false
+		state.files = 0
+		state.Unlock()
+	})
+
+	result, rpcErr := handleControl(rpcRequest{JSONRPC: "2.0", Method: "status"})
+	if rpcErr != nil {
+		t.Fatalf("got error %v", rpcErr.Message)
+	}
+
+	status, ok := result.(controlStatus)
+	if !ok {
+		t.Fatalf("got a %T, want a controlStatus", result)
+	}
+	if !status.Paused || status.Files != 42 {
+		t.Errorf("got paused %v with %v files, want paused with 42 files", status.Paused, status.Files)
+	}
+}
+
+func TestHandleControlReload(t *testing.T) {
+	t.Cleanup(func() { reload = nil })
+
+	reload = nil
+	if _, rpcErr := handleControl(rpcRequest{JSONRPC: "2.0", Method: "reload"}); rpcErr == nil || rpcErr.Code != rpcServerError {
+		t.Errorf("reloading without a config file gave %v, want a server error", rpcErr)
+	}
+
+	var reloaded bool
+	reload = func() error {
+		reloaded = true
+
+		return nil
+	}
+	if result, rpcErr := handleControl(rpcRequest{JSONRPC: "2.0", Method: "reload"}); rpcErr != nil || result != true {
+		t.Errorf("reload gave %v, %v, want true", result, rpcErr)
+	}
+	if !reloaded {
+		t.Error("reload didn't reload the config")
+	}
+
+	reload = func() error { return errors.New("bad config") }
+	if _, rpcErr := handleControl(rpcRequest{JSONRPC: "2.0", Method: "reload"}); rpcErr == nil || rpcErr.Message != "bad config" {
+		t.Errorf("a failed reload gave %v, want the reload error", rpcErr)
+	}
+}
+
+func TestHandleControlErrors(t *testing.T) {
+	tests := []struct {
+		req  rpcRequest
+		want int
+	}{
+		{rpcRequest{JSONRPC: "1.0", Method: "status"}, rpcInvalidRequest},
+		{rpcRequest{Method: "status"}, rpcInvalidRequest},
+		{rpcRequest{JSONRPC: "2.0", Method: "restart"}, rpcMethodNotFound},
+	}
+
+	for _, tt := range tests {
+		if _, rpcErr := handleControl(tt.req); rpcErr == nil || rpcErr.Code != tt.want {
+			t.Errorf("handleControl(%+v) gave %v, want code %v", tt.req, rpcErr, tt.want)
+		}
+	}
+}
+
+func TestHandleControlSubscribe(t *testing.T) {
+	if result, rpcErr := handleControl(rpcRequest{JSONRPC: "2.0", Method: "subscribe"}); rpcErr != nil || result != true {
+		t.Errorf("subscribe gave %v, %v, want true", result, rpcErr)
+	}
+}
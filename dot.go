@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// explicitDot reports whether any of the given watch patterns explicitly
+// names the dot file or dot directory at path
+//
+// A pattern names a dot file explicitly when it matches the full path and its
+// final element starts with a literal dot, so ".env" and "config/.*rc" do but
+// "*" and "*.env" don't
+// A pattern names a dot directory explicitly when the directory could contain
+// a file it matches and the element matching the directory starts with a
+// literal dot, so ".github/*.yml" names the ".github" directory
+func explicitDot(patterns []string, path string, isDir bool) bool {
+	elems := strings.Split(path, "/")
+	for _, pattern := range patterns {
+		patternElems := strings.Split(pattern, "/")
+
+		if isDir && len(patternElems) <= len(elems) {
+			continue
+		}
+		if !isDir && len(patternElems) != len(elems) {
+			continue
+		}
+
+		matched := true
+		for i, elem := range elems {
+			if ok, _ := filepath.Match(patternElems[i], elem); !ok {
+				matched = false
+
+				break
+			}
+		}
+
+		if matched && strings.HasPrefix(patternElems[len(elems)-1], ".") {
+			return true
+		}
+	}
+
+	return false
+}
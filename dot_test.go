@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+// The cases follow the table of dot files and patterns in the README
+func TestExplicitDot(t *testing.T) {
+	tests := []struct {
+		path     string
+		isDir    bool
+		patterns []string
+		want     bool
+	}{
+		{".env", false, nil, false},
+		{".env", false, []string{".env"}, true},
+		{".env", false, []string{"*"}, false},
+		{".env", false, []string{"*.env"}, false},
+		{".env", false, []string{".*"}, true},
+		{".env", false, []string{"*.go", ".env"}, true},
+		{".hidden.go", false, nil, false},
+		{".github", true, []string{".github/*.yml"}, true},
+		{".github", true, []string{"*/*.yml"}, false},
+		{".github", true, []string{".github"}, false},
+		{".github/.cache", true, []string{".github/*.yml"}, false},
+		{"config/.bashrc", false, []string{"config/.*rc"}, true},
+		{"config/.bashrc", false, []string{".*rc"}, false},
+		{"other/.bashrc", false, []string{"config/.*rc"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := explicitDot(tt.patterns, tt.path, tt.isDir); got != tt.want {
+			t.Errorf("explicitDot(%q, %q, %v) = %v, want %v", tt.patterns, tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// event describes something that happened in the watcher, such as a run
+// starting or finishing, for consumers outside of the process
+type event struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	result
+}
+
+// eventBuffer is the number of events that can be queued for a subscriber
+// before further events are dropped
+const eventBuffer = 64
+
+var (
+	subsMu sync.Mutex
+	subs   = make(map[chan event]struct{})
+)
+
+// subscribe returns a channel that receives all published events along with
+// a function to stop receiving them
+func subscribe() (<-chan event, func()) {
+	ch := make(chan event, eventBuffer)
+
+	subsMu.Lock()
+	subs[ch] = struct{}{}
+	subsMu.Unlock()
+
+	return ch, func() {
+		subsMu.Lock()
+		delete(subs, ch)
+		close(ch)
+		subsMu.Unlock()
+	}
+}
+
+// publish sends an event to all subscribers without waiting on any of them,
+// so a subscriber that falls behind misses events
+func publish(e event) {
+	if opts.logJSON {
+		logEvent(e)
+	}
+
+	subsMu.Lock()
+	defer subsMu.Unlock()
+
+	for ch := range subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
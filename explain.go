@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// plan is the set of commands that would run for a change to a file
+type plan struct {
+	File     string     `json:"file"`
+	Watched  bool       `json:"watched"`
+	OnChange string     `json:"onChange,omitempty"`
+	Summary  string     `json:"summary,omitempty"`
+	Commands []planStep `json:"commands"`
+}
+
+type planStep struct {
+	Group   string   `json:"group"`
+	Command string   `json:"command"`
+	Program string   `json:"program"`
+	Args    []string `json:"args"`
+	Dir     string   `json:"dir,omitempty"`
+	Env     []string `json:"env,omitempty"`
+}
+
+// explain works out which commands would run if the file at path changed,
+// resolving the same shorthand, groups, and parsing as a real run
+func explain(path string, skip func(string, fs.DirEntry) bool) plan {
+	path = filepath.Clean(path)
+
+	p := plan{
+		File:     filepath.ToSlash(path),
+		Watched:  !skipped(path, skip),
+		OnChange: opts.onChange,
+		Summary:  opts.summaryCmd,
+		Commands: []planStep{},
+	}
+
+	if !p.Watched {
+		return p
+	}
+
+	p.Commands = planSteps([]string{path})
+
+	return p
+}
+
+// planSteps resolves the commands of every runner affected by a change to the
+// given files, with their placeholders filled in the same way as a real run
+func planSteps(changed []string) []planStep {
+	steps := []planStep{}
+	for _, r := range runners {
+		files, ok := r.affected("change", changed)
+		if !ok {
+			continue
+		}
+
+		group := strings.Join(slices.Sorted(maps.Keys(r.exts)), " ")
+
+		cmds, cmdFiles := expandPlaceholders(r.cmds, files)
+		for i, cmdStr := range cmds {
+			ce, rest := cutCmdEnv(cmdStr)
+			dir := ce.workdir()
+			files := relativePaths(dir, cmdFiles[i])
+
+			step := planStep{
+				Group:   group,
+				Command: cmdStr,
+				Dir:     dir,
+				Env:     ce.env,
+			}
+
+			// With -shell the whole command is given to the shell as it is
+			if opts.shell {
+				step.Program = expandShell(strings.TrimSpace(rest), files)
+				step.Args = []string{}
+			} else {
+				_, fields := cutStdin(split(rest))
+				fields = expandFields(fields, files)
+				if len(fields) == 0 {
+					continue
+				}
+
+				program, args := fields[0], fields[1:]
+				if remote != nil && opts.remoteExec {
+					program, args = remote.command(program, args)
+				}
+
+				step.Program = program
+				step.Args = append([]string{}, args...)
+			}
+
+			steps = append(steps, step)
+		}
+	}
+
+	return steps
+}
+
+// skipped reports whether the walk would skip path, either directly or by
+// skipping one of the directories leading to it
+// The file doesn't need to exist, in which case it's treated as a regular file
+func skipped(path string, skip func(string, fs.DirEntry) bool) bool {
+	elems := strings.Split(filepath.ToSlash(path), "/")
+	for i := 1; i < len(elems); i++ {
+		dir := filepath.FromSlash(strings.Join(elems[:i], "/"))
+
+		if skip(dir, &remoteEntry{name: elems[i-1], isDir: true}) {
+			return true
+		}
+	}
+
+	var entry fs.DirEntry = &remoteEntry{name: filepath.Base(path)}
+	if fi, err := os.Lstat(path); err == nil {
+		entry = fs.FileInfoToDirEntry(fi)
+	}
+
+	return skip(path, entry)
+}
+
+func (p plan) print(w io.Writer, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(p)
+	}
+
+	if !p.Watched {
+		fmt.Fprintf(w, "%v is not watched\n", p.File)
+
+		return nil
+	}
+
+	if p.OnChange != "" {
+		fmt.Fprintf(w, "on-change: %v\n", p.OnChange)
+	}
+	if p.Summary != "" {
+		fmt.Fprintf(w, "summary: %v\n", p.Summary)
+	}
+
+	if len(p.Commands) == 0 {
+		fmt.Fprintf(w, "%v is watched but no commands would run\n", p.File)
+
+		return nil
+	}
+
+	printSteps(w, p.Commands)
+
+	return nil
+}
+
+// printSteps prints numbered commands under a heading for each group
+func printSteps(w io.Writer, steps []planStep) {
+	group := "-"
+	for i, step := range steps {
+		if step.Group != group {
+			group = step.Group
+
+			if group == "" {
+				fmt.Fprintln(w, "any change:")
+			} else {
+				fmt.Fprintf(w, "[%v]:\n", group)
+			}
+		}
+
+		_, _, message := command(step.Program, step.Args...)
+
+		// The directory and environment are shown the way they're given
+		var assignments []string
+		if step.Dir != "" {
+			assignments = append(assignments, "dir="+step.Dir)
+		}
+		for _, env := range step.Env {
+			assignments = append(assignments, "env="+env)
+		}
+
+		fmt.Fprintf(w, "  %v. %v\n", i+1, strings.TrimSpace(strings.Join(append(assignments, message), " ")))
+	}
+}
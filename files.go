@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// watchList is the set of paths given to -files, which are checked directly
+// instead of walking the current directory
+type watchList map[string]bool
+
+func parseWatchList(s string) watchList {
+	list := make(watchList)
+	for _, path := range strings.Fields(s) {
+		list[filepath.Clean(path)] = true
+	}
+
+	return list
+}
+
+// walk calls fn for each listed path that exists, in the same way as
+// filepath.WalkDir would for a file
+// Paths that don't exist or can't be read are left out, so a listed file
+// that's deleted isn't seen on the next pass and is reported as a deletion
+// like any other file
+func (l watchList) walk(fn fs.WalkDirFunc) error {
+	for _, path := range l.paths() {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		if err := fn(path, fs.FileInfoToDirEntry(fi), nil); err != nil && err != filepath.SkipDir {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// paths returns the listed paths in a stable order
+func (l watchList) paths() []string {
+	paths := make([]string, 0, len(l))
+	for path := range l {
+		paths = append(paths, path)
+	}
+
+	slices.Sort(paths)
+
+	return paths
+}
+
+// dirs returns the directories containing the listed paths
+// Watching the directories rather than the files themselves means that files
+// replaced by an editor or deleted and recreated are still seen
+func (l watchList) dirs() []string {
+	var dirs []string
+	for _, path := range l.paths() {
+		if dir := filepath.Dir(path); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
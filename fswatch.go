@@ -0,0 +1,152 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// safetyScanInterval is how often a scan happens in -notify mode without
+// any events, to catch anything the events missed and anything that depends
+// on time passing, such as -active-hours
+const safetyScanInterval = 30 * time.Second
+
+// fsWatcher wakes the main loop when the operating system reports a change
+// to a watched file, so that a scan only happens when something changed
+//
+// Scans work the same way as when polling so that both modes see the same
+// files and changes, only the trigger for a scan is different
+type fsWatcher struct {
+	w    *fsnotify.Watcher
+	skip func(string, fs.DirEntry) bool
+
+	// wake receives a value when a scan should happen
+	// It's buffered so that a burst of events only causes one scan
+	wake chan struct{}
+
+	// partial is set if some directories couldn't be watched, in which
+	// case scans keep happening on the normal interval as well
+	partial atomic.Bool
+
+	// fixed is set when only the given directories are watched, so new
+	// directories created inside them aren't added
+	fixed bool
+}
+
+// newFSWatcher starts watching every directory under the current directory
+// that isn't skipped, or only the given directories if there are any
+func newFSWatcher(skip func(string, fs.DirEntry) bool, dirs []string) (*fsWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	fw := &fsWatcher{
+		w:     w,
+		skip:  skip,
+		wake:  make(chan struct{}, 1),
+		fixed: len(dirs) > 0,
+	}
+
+	if fw.fixed {
+		for _, dir := range dirs {
+			if err := w.Add(dir); err != nil && !fw.partial.Swap(true) {
+				logf(levelError, "watch notify error: %v: %v, polling as well", dir, err)
+			}
+		}
+	} else if err := fw.addTree("."); err != nil {
+		w.Close()
+
+		return nil, err
+	}
+
+	go fw.loop()
+
+	return fw, nil
+}
+
+// addTree adds the directory at root and every directory under it that isn't
+// skipped
+// Directories that can't be added are left to the polling interval
+func (fw *fsWatcher) addTree(root string) error {
+	return walkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// The root may have gone again since it was created
+			if path == root {
+				return err
+			}
+
+			return nil
+		}
+
+		if !entry.IsDir() {
+			return nil
+		}
+
+		// The current directory is skipped as a path, but always watched
+		if path != "." && fw.skip(path, entry) {
+			return filepath.SkipDir
+		}
+
+		if err := fw.w.Add(path); err != nil {
+			if !fw.partial.Swap(true) {
+				logf(levelError, "watch notify error: %v: %v, polling as well", path, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (fw *fsWatcher) loop() {
+	for {
+		select {
+		case ev, ok := <-fw.w.Events:
+			if !ok {
+				return
+			}
+
+			path := filepath.Clean(ev.Name)
+
+			// New directories need watching too, and anything created
+			// inside them before they were added is picked up by the scan
+			if ev.Has(fsnotify.Create) && !fw.fixed {
+				if fi, err := os.Lstat(path); err == nil && fi.IsDir() && !skipped(path, fw.skip) {
+					fw.addTree(path)
+					fw.notify()
+
+					continue
+				}
+			}
+
+			// Changes to files that aren't watched don't need a scan, but
+			// removals always do since the file can't be checked any more
+			// The trigger file needs a scan even though it isn't watched
+			if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) || !skipped(path, fw.skip) || path == filepath.Clean(opts.triggerFile) {
+				fw.notify()
+			}
+
+		case err, ok := <-fw.w.Errors:
+			if !ok {
+				return
+			}
+
+			// Dropped events mean changes may have been missed, so scan
+			logf(levelError, "watch notify error: %v", err)
+
+			fw.notify()
+		}
+	}
+}
+
+// notify asks for a scan without waiting if one is already pending
+func (fw *fsWatcher) notify() {
+	select {
+	case fw.wake <- struct{}{}:
+	default:
+	}
+}
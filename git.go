@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gitHead tracks the checked out branch of the repository in the current
+// directory so that a branch switch can be treated as a single change
+//
+// The .git directory is normally skipped by the walk, so HEAD and the index
+// are read directly rather than being picked up as regular watched files
+type gitHead struct {
+	dir      string
+	head     string
+	indexMod time.Time
+
+	// settling is set when git starts changing things and cleared once a
+	// pass goes by without any changes, and switched is set if HEAD moved
+	// in the meantime
+	settling bool
+	switched bool
+}
+
+// poll reads the current state of HEAD and the index
+// It returns the new branch name if HEAD changed since the last poll, and
+// whether git is busy, which is the case while the index is locked or when
+// it was modified, as happens repeatedly during a checkout
+func (g *gitHead) poll() (branch string, switched bool, busy bool) {
+	if g.dir == "" {
+		g.dir = gitDir(".")
+		if g.dir == "" {
+			return "", false, false
+		}
+	}
+
+	if b, err := os.ReadFile(filepath.Join(g.dir, "HEAD")); err == nil {
+		head := strings.TrimSpace(string(b))
+
+		switched = g.head != "" && head != g.head
+		g.head = head
+	}
+
+	if fi, err := os.Stat(filepath.Join(g.dir, "index")); err == nil {
+		busy = !g.indexMod.IsZero() && !fi.ModTime().Equal(g.indexMod)
+		g.indexMod = fi.ModTime()
+	}
+
+	if _, err := os.Stat(filepath.Join(g.dir, "index.lock")); err == nil {
+		busy = true
+	}
+
+	return branchName(g.head), switched, busy || switched
+}
+
+// update polls HEAD and the index, starting to settle if git is busy, and
+// reports whether it is
+func (g *gitHead) update() bool {
+	branch, switched, busy := g.poll()
+	if switched {
+		g.switched = true
+
+		logf(levelDebug, "watch: branch changed to %v", branch)
+	}
+
+	if busy {
+		g.settling = true
+	}
+
+	return busy
+}
+
+// gitDir returns the path to the git directory for the repository rooted at
+// root, following the "gitdir:" indirection used by worktrees and submodules
+func gitDir(root string) string {
+	path := filepath.Join(root, ".git")
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	if fi.IsDir() {
+		return path
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	dir, ok := strings.CutPrefix(strings.TrimSpace(string(b)), "gitdir:")
+	if !ok {
+		return ""
+	}
+
+	dir = strings.TrimSpace(dir)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+
+	return dir
+}
+
+// branchName converts the contents of a HEAD file into a branch name, or a
+// short commit hash if HEAD is detached
+func branchName(head string) string {
+	if ref, ok := strings.CutPrefix(head, "ref:"); ok {
+		return strings.TrimPrefix(strings.TrimSpace(ref), "refs/heads/")
+	}
+
+	if len(head) > 7 {
+		return head[:7]
+	}
+
+	return head
+}
+
+// changedSince returns the files under the current directory that differ
+// from the given revision, including uncommitted changes but not deletions
+func changedSince(rev string) ([]string, error) {
+	if err := exec.Command("git", "rev-parse", "--is-inside-work-tree").Run(); err != nil {
+		return nil, errors.New("not in a git repository")
+	}
+
+	cmd := exec.Command("git", "diff", "--name-only", "--relative", "--diff-filter=d", rev, "--")
+
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			msg, _, _ := strings.Cut(strings.TrimSpace(string(exitErr.Stderr)), "\n")
+
+			return nil, fmt.Errorf("git diff: %v", msg)
+		}
+
+		return nil, err
+	}
+
+	var files []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" {
+			files = append(files, filepath.FromSlash(line))
+		}
+	}
+
+	return files, nil
+}
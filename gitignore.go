@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// gitignore matches paths against the .gitignore files in the directories
+// leading to them
+//
+// Each .gitignore file is read the first time a path under its directory is
+// checked, so changes to it aren't seen until watch is restarted
+type gitignore struct {
+	mu   sync.Mutex
+	dirs map[string][]ignoreRule
+}
+
+// ignoreRule is a single pattern from a .gitignore file
+type ignoreRule struct {
+	glob string
+
+	// negate re-includes paths that an earlier rule excluded
+	negate bool
+
+	// dirOnly rules only match directories
+	dirOnly bool
+
+	// anchored rules are matched against the path relative to the directory
+	// of their .gitignore file rather than against any name within it
+	anchored bool
+}
+
+func newGitignore() *gitignore {
+	return &gitignore{dirs: make(map[string][]ignoreRule)}
+}
+
+// ignored reports whether the slash separated path is ignored
+// Rules are checked from the top-most .gitignore file down, and the last rule
+// to match decides, so deeper files and later lines take precedence
+func (g *gitignore) ignored(path string, isDir bool) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var ignored bool
+	elems := strings.Split(path, "/")
+	for i := range elems {
+		dir := strings.Join(elems[:i], "/")
+		rel := strings.Join(elems[i:], "/")
+
+		for _, rule := range g.rules(dir) {
+			if rule.match(rel, isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+
+	return ignored
+}
+
+// rules returns the rules from the .gitignore file in dir, reading it if it
+// hasn't been read yet
+// It must be called with the lock held
+func (g *gitignore) rules(dir string) []ignoreRule {
+	if rules, ok := g.dirs[dir]; ok {
+		return rules
+	}
+
+	rules := parseGitignore(filepath.Join(filepath.FromSlash(dir), ".gitignore"))
+	g.dirs[dir] = rules
+
+	return rules
+}
+
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	if r.anchored {
+		matched, _ := matchPattern(r.glob, rel)
+
+		return matched
+	}
+
+	matched, _ := filepath.Match(r.glob, path.Base(rel))
+
+	return matched
+}
+
+// parseGitignore reads the rules from a .gitignore file
+// A missing or unreadable file has no rules
+func parseGitignore(name string) []ignoreRule {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil
+	}
+
+	var rules []ignoreRule
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+
+		// Trailing spaces are ignored unless they're escaped
+		if !strings.HasSuffix(line, `\ `) {
+			line = strings.TrimRight(line, " ")
+		}
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var r ignoreRule
+		switch {
+		case strings.HasPrefix(line, "!"):
+			r.negate = true
+			line = line[1:]
+
+		case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+			line = line[1:]
+		}
+
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+
+		// A slash anywhere but the end anchors the pattern to the directory
+		// of the .gitignore file
+		if strings.Contains(line, "/") {
+			r.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+
+		if line == "" {
+			continue
+		}
+
+		r.glob = line
+		rules = append(rules, r)
+	}
+
+	return rules
+}
@@ -1,3 +1,10 @@
 module github.com/polyscone/watch
 
-go 1.24
+go 1.24.0
+
+require (
+	github.com/fsnotify/fsnotify v1.10.1
+	golang.org/x/term v0.36.0
+)
+
+require golang.org/x/sys v0.37.0 // indirect
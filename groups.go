@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// stringList is a flag value that can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+
+	return nil
+}
+
+// requireGroup is a set of files that must all be updated before a change to
+// any one of them is allowed to trigger a run
+type requireGroup struct {
+	patterns []string
+	files    map[string]time.Time
+	pending  bool
+}
+
+func newRequireGroups(values []string) []*requireGroup {
+	var groups []*requireGroup
+	for _, value := range values {
+		patterns := strings.Fields(value)
+		if len(patterns) == 0 {
+			continue
+		}
+
+		groups = append(groups, &requireGroup{patterns: patterns})
+	}
+
+	return groups
+}
+
+// match reports whether the given slash separated path belongs to the group
+func (g *requireGroup) match(path string) bool {
+	for _, pattern := range g.patterns {
+		matched, err := matchPattern(pattern, path)
+		if err != nil {
+			logf(levelError, "watch require-all pattern error: %v", err)
+		}
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// complete reports whether every file in the group was modified after since
+func (g *requireGroup) complete(since time.Time) bool {
+	if len(g.files) == 0 {
+		return false
+	}
+
+	for _, modified := range g.files {
+		if !modified.After(since) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// completeGroups reports whether any group with pending changes has had all
+// of its files modified after since, and clears the pending changes of those
+// that have
+func completeGroups(groups []*requireGroup, since time.Time) bool {
+	var complete bool
+	for _, group := range groups {
+		if group.pending && group.complete(since) {
+			complete = true
+			group.pending = false
+		}
+	}
+
+	return complete
+}
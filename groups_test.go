@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequireGroupComplete(t *testing.T) {
+	lastRun := time.Now()
+	before := lastRun.Add(-time.Second)
+	after := lastRun.Add(time.Second)
+
+	tests := []struct {
+		name  string
+		files map[string]time.Time
+		want  bool
+	}{
+		{"no files", nil, false},
+		{"none updated", map[string]time.Time{"a.proto": before, "a.pb.go": before}, false},
+		{"some updated", map[string]time.Time{"a.proto": after, "a.pb.go": before}, false},
+		{"all updated", map[string]time.Time{"a.proto": after, "a.pb.go": after}, true},
+		{"updated at the run", map[string]time.Time{"a.proto": after, "a.pb.go": lastRun}, false},
+	}
+
+	for _, tt := range tests {
+		g := &requireGroup{files: tt.files}
+		if got := g.complete(lastRun); got != tt.want {
+			t.Errorf("%v: complete() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRequireGroupMatch(t *testing.T) {
+	g := newRequireGroups([]string{"*.proto *.pb.go", "  "})
+	if len(g) != 1 {
+		t.Fatalf("newRequireGroups gave %v groups, want 1 without the empty one", len(g))
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"api.proto", true},
+		{"api.pb.go", true},
+		{"main.go", false},
+	}
+
+	for _, tt := range tests {
+		if got := g[0].match(tt.path); got != tt.want {
+			t.Errorf("match(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCompleteGroups(t *testing.T) {
+	lastRun := time.Now()
+	before := lastRun.Add(-time.Second)
+	after := lastRun.Add(time.Second)
+
+	g := &requireGroup{files: map[string]time.Time{"api.proto": after, "api.pb.go": before}}
+	groups := []*requireGroup{g}
+
+	// The .proto file was saved but the generated code hasn't caught up yet
+	g.pending = true
+	if completeGroups(groups, lastRun) {
+		t.Fatal("a group ran with only some of its files updated")
+	}
+	if !g.pending {
+		t.Fatal("an incomplete group stopped waiting for the rest of its files")
+	}
+
+	// Once the generated code is written the group runs, once
+	g.files["api.pb.go"] = after
+	if !completeGroups(groups, lastRun) {
+		t.Fatal("a group didn't run once all of its files were updated")
+	}
+	if g.pending {
+		t.Error("a group was still pending after it ran")
+	}
+	if completeGroups(groups, lastRun) {
+		t.Error("a complete group ran again without any new changes")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"hash/fnv"
+	"io"
+	"os"
+	"time"
+)
+
+// fileState is what the main loop remembers about a watched file between
+// scans
+type fileState struct {
+	modTime time.Time
+	size    int64
+
+	// Directories only decide whether to run, and aren't passed to commands
+	// as changed files
+	dir bool
+
+	// hash is only set if hashed is, which depends on the hashing options
+	// and the size of the file
+	hash   uint64
+	hashed bool
+}
+
+// hashFile returns a hash of the file's content, or false if the file is
+// bigger than -hash-limit or can't be read
+func hashFile(path string, size int64) (uint64, bool) {
+	if size > opts.hashLimit {
+		return 0, false
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	h := fnv.New64a()
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, false
+	}
+
+	return h.Sum64(), true
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+// history passes writes through to another writer while remembering the
+// last few complete lines so they can be printed again after a clear
+type history struct {
+	mu      sync.Mutex
+	w       io.Writer
+	max     int
+	lines   []string
+	partial string
+}
+
+func (h *history) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	text := h.partial + strings.ReplaceAll(string(p), "\a", "")
+	lines := strings.Split(text, "\n")
+
+	h.partial = lines[len(lines)-1]
+	h.lines = append(h.lines, lines[:len(lines)-1]...)
+	if len(h.lines) > h.max {
+		h.lines = h.lines[len(h.lines)-h.max:]
+	}
+
+	return h.w.Write(p)
+}
+
+// last returns a copy of the remembered lines
+func (h *history) last() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]string(nil), h.lines...)
+}
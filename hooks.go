@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// changeSet is the structured description of a run's trigger that is given
+// to external commands as JSON
+type changeSet struct {
+	Trigger string   `json:"trigger"`
+	Files   []string `json:"files"`
+}
+
+// runHook runs a command string with the change set given as JSON on stdin
+// The same JSON is also written to a temporary file whose path is set in the
+// WATCH_CHANGES_FILE environment variable for commands that can't read stdin
+func runHook(cmdStr string, changes changeSet, args ...string) error {
+	fields := split(cmdStr)
+	if len(fields) == 0 {
+		return errors.New("empty command")
+	}
+
+	if changes.Files == nil {
+		changes.Files = []string{}
+	}
+
+	b, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp("", "watch-changes-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(b)
+	if err := errors.Join(err, f.Close()); err != nil {
+		return err
+	}
+
+	cmd := exec.Command(fields[0], append(fields[1:], args...)...)
+	cmd.Env = append(os.Environ(), "WATCH_CHANGES_FILE="+f.Name())
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// now is the clock used to check the active hours
+var now = time.Now
+
+// hold keeps track of changes made while runs aren't allowed, such as while
+// paused or outside the active hours, so that they cause a run once runs are
+// allowed again
+type hold struct {
+	missed bool
+}
+
+// allow reports whether to run now, given whether a run is due and whether
+// runs are allowed at the moment
+func (h *hold) allow(shouldRun, allowed bool) bool {
+	if !allowed {
+		h.missed = h.missed || shouldRun
+
+		return false
+	}
+
+	if h.missed {
+		h.missed = false
+
+		return true
+	}
+
+	return shouldRun
+}
+
+// activeWindow is a daily period during which changes cause runs
+type activeWindow struct {
+	days  [7]bool
+	start int
+	end   int
+}
+
+// schedule is the set of windows given to -active-hours
+// An empty schedule is always active
+type schedule []activeWindow
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseSchedule parses a comma separated list of windows where each window
+// is an optional day or day range followed by a time range, for example:
+// "Mon-Fri 09:00-17:30, Sat 10:00-12:00"
+// Time ranges that end before they start run past midnight into the next day
+func parseSchedule(spec string) (schedule, error) {
+	var s schedule
+	for _, part := range strings.Split(spec, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("invalid window %q", strings.TrimSpace(part))
+		}
+
+		var w activeWindow
+		if len(fields) == 1 {
+			w.days = [7]bool{true, true, true, true, true, true, true}
+		} else {
+			first, last, _ := strings.Cut(strings.ToLower(fields[0]), "-")
+			if last == "" {
+				last = first
+			}
+
+			from, ok := dayNames[first]
+			if !ok {
+				return nil, fmt.Errorf("unknown day %q", first)
+			}
+			to, ok := dayNames[last]
+			if !ok {
+				return nil, fmt.Errorf("unknown day %q", last)
+			}
+
+			for d := from; ; d = (d + 1) % 7 {
+				w.days[d] = true
+
+				if d == to {
+					break
+				}
+			}
+		}
+
+		times := fields[len(fields)-1]
+		start, end, ok := strings.Cut(times, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid time range %q", times)
+		}
+
+		var err error
+		if w.start, err = parseClock(start); err != nil {
+			return nil, err
+		}
+		if w.end, err = parseClock(end); err != nil {
+			return nil, err
+		}
+
+		s = append(s, w)
+	}
+
+	return s, nil
+}
+
+// parseClock converts a HH:MM time into minutes since midnight
+func parseClock(str string) (int, error) {
+	t, err := time.Parse("15:04", str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", str)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// active reports whether t falls within any of the windows
+func (s schedule) active(t time.Time) bool {
+	if len(s) == 0 {
+		return true
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+
+	for _, w := range s {
+		if w.start < w.end {
+			if w.days[today] && minute >= w.start && minute < w.end {
+				return true
+			}
+
+			continue
+		}
+
+		// The window runs past midnight, so it's either in the part that
+		// started today or the part that started yesterday
+		if w.days[today] && minute >= w.start {
+			return true
+		}
+		if w.days[yesterday] && minute < w.end {
+			return true
+		}
+	}
+
+	return false
+}
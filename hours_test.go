@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// setClock replaces the clock used for the active hours for the rest of the
+// test and returns a function to move it to another time
+func setClock(t *testing.T, start time.Time) func(time.Time) {
+	prev := now
+	t.Cleanup(func() { now = prev })
+
+	current := start
+	now = func() time.Time { return current }
+
+	return func(t time.Time) { current = t }
+}
+
+// 2026-10-12 is a Monday
+func at(day int, clock string) time.Time {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		panic(err)
+	}
+
+	return time.Date(2026, time.October, 12+day, t.Hour(), t.Minute(), 0, 0, time.Local)
+}
+
+func TestScheduleActive(t *testing.T) {
+	tests := []struct {
+		spec string
+		t    time.Time
+		want bool
+	}{
+		{"", at(5, "03:00"), true},
+		{"09:00-17:00", at(0, "09:00"), true},
+		{"09:00-17:00", at(0, "16:59"), true},
+		{"09:00-17:00", at(0, "17:00"), false},
+		{"09:00-17:00", at(6, "08:59"), false},
+		{"Mon-Fri 09:00-17:00", at(4, "12:00"), true},
+		{"Mon-Fri 09:00-17:00", at(5, "12:00"), false},
+		{"Mon-Fri 09:00-17:00, Sat 10:00-12:00", at(5, "11:00"), true},
+		{"Fri-Mon 09:00-17:00", at(6, "12:00"), true},
+		{"Fri-Mon 09:00-17:00", at(1, "12:00"), false},
+		{"Fri 22:00-02:00", at(4, "23:00"), true},
+		{"Fri 22:00-02:00", at(5, "01:00"), true},
+		{"Fri 22:00-02:00", at(5, "23:00"), false},
+		{"Fri 22:00-02:00", at(4, "01:00"), false},
+	}
+
+	for _, tt := range tests {
+		s, err := parseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("parseSchedule(%q) failed: %v", tt.spec, err)
+
+			continue
+		}
+
+		if got := s.active(tt.t); got != tt.want {
+			t.Errorf("%q active at %v = %v, want %v", tt.spec, tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{"9-5", "Mon 09:00", "Someday 09:00-17:00", "Mon-Fri 09:00-25:00", "Mon Tue 09:00-17:00"} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("parseSchedule(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestHoldOutsideActiveHours(t *testing.T) {
+	hours, err := parseSchedule("Mon-Fri 09:00-17:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setTime := setClock(t, at(4, "16:00"))
+
+	// pass does what the main loop does on each pass
+	var held hold
+	pass := func(shouldRun bool) bool {
+		return held.allow(shouldRun, hours.active(now()))
+	}
+
+	if !pass(true) {
+		t.Fatal("a change in the active hours didn't run")
+	}
+
+	// Changes on Friday evening and over the weekend are held back
+	setTime(at(4, "18:00"))
+	if pass(true) {
+		t.Error("a change after the active hours ran")
+	}
+
+	setTime(at(5, "12:00"))
+	if pass(true) || pass(false) {
+		t.Error("a change at the weekend ran")
+	}
+
+	// Monday morning catches up with one run, even without a new change
+	setTime(at(7, "09:00"))
+	if !pass(false) {
+		t.Fatal("the changes made outside the active hours weren't caught up on")
+	}
+	if pass(false) {
+		t.Error("the changes made outside the active hours were caught up on twice")
+	}
+}
+
+func TestHoldNothingMissed(t *testing.T) {
+	hours, err := parseSchedule("Mon-Fri 09:00-17:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setTime := setClock(t, at(5, "12:00"))
+
+	var held hold
+	if held.allow(false, hours.active(now())) {
+		t.Error("ran at the weekend without any changes")
+	}
+
+	setTime(at(7, "09:00"))
+	if held.allow(false, hours.active(now())) {
+		t.Error("ran when the active hours started without any changes to catch up on")
+	}
+}
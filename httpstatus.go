@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// serveStatus starts an HTTP server at addr with GET /status, which returns
+// the same status as the control socket, and POST /trigger, which runs the
+// commands straight away
+// An address without a host is only served on localhost
+func serveStatus(addr string) error {
+	ln, err := net.Listen("tcp", listenAddr(addr))
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		json.NewEncoder(w).Encode(currentStatus())
+	})
+	mux.HandleFunc("POST /trigger", func(w http.ResponseWriter, r *http.Request) {
+		// Wait for the run to start so that a status request made after
+		// the response sees it
+		done := make(chan struct{})
+		actions <- action{kind: actionRun, done: done}
+		<-done
+
+		w.Header().Set("Content-Type", "application/json")
+
+		fmt.Fprintln(w, "true")
+	})
+
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			logf(levelError, "watch http error: %v", err)
+		}
+	}()
+
+	logf(levelDebug, "watch: serving the status at http://%v/status", ln.Addr())
+
+	return nil
+}
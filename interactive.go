@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// action is a request made from outside of the main loop, such as a key
+// press in interactive mode, that the main loop acts on between scans
+type action struct {
+	kind  string
+	index int
+
+	// commands are the new commands for a reload
+	commands []string
+
+	// runner is the runner to restart and id is the run that exited, so
+	// that the restart can be dropped if something else ran since
+	runner *runner
+	id     int64
+
+	// done is closed once the action has been handled if it's set
+	done chan struct{}
+}
+
+const (
+	actionRun     = "run"
+	actionClear   = "clear"
+	actionQuit    = "quit"
+	actionPause   = "pause"
+	actionResume  = "resume"
+	actionToggle  = "toggle"
+	actionReload  = "reload"
+	actionRestart = "restart"
+)
+
+var actions = make(chan action)
+
+const interactiveHelp = "watch: keys: r rerun, c clear, p pause/resume, q quit, 1-9 run a single command"
+
+// sttyState holds the terminal settings to restore on exit when the terminal
+// was switched out of line buffered mode
+var sttyState string
+
+// startInteractive starts reading key presses from stdin and sending them to
+// the main loop as actions
+//
+// On linux/mac, if stdin is a terminal, it's switched out of canonical mode
+// with stty so that keys don't need to be followed by enter
+// Elsewhere, keys are read a line at a time
+func startInteractive() {
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 && runtime.GOOS != "windows" {
+		if state, err := stty("-g"); err == nil {
+			if _, err := stty("-icanon", "-echo"); err == nil {
+				sttyState = state
+			}
+		}
+	}
+
+	logf(levelInfo, "%v", interactiveHelp)
+
+	go func() {
+		r := bufio.NewReader(os.Stdin)
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+
+			switch {
+			case b == 'r':
+				actions <- action{kind: actionRun}
+			case b == 'c':
+				actions <- action{kind: actionClear}
+			case b == 'q':
+				actions <- action{kind: actionQuit}
+			case b == 'p':
+				actions <- action{kind: actionToggle}
+			case b >= '1' && b <= '9':
+				actions <- action{kind: actionRun, index: int(b - '0')}
+			}
+		}
+	}()
+}
+
+// quit kills any running processes, restores the terminal, and exits
+func quit(code int) {
+	killAll(runners)
+
+	exit(code)
+}
+
+// finish waits for the latest runs to finish and exits with the exit code of
+// the last one to report
+func finish() {
+	for _, r := range runners {
+		r.wait()
+	}
+
+	reportMu.Lock()
+	code := lastExitCode
+	reportMu.Unlock()
+
+	exit(code)
+}
+
+// exit restores the terminal and exits without waiting on any processes
+func exit(code int) {
+	if sttyState != "" {
+		stty(sttyState)
+	}
+
+	os.Exit(code)
+}
+
+// handleQuitSignals stops any running commands and exits when watch is
+// interrupted or terminated, so that things like servers aren't left behind
+// holding on to their ports
+// A second signal while waiting for the commands to exit exits straight away
+func handleQuitSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+
+		// Exit with the usual status for being killed by the signal
+		code := 1
+		if s, ok := sig.(syscall.Signal); ok {
+			code = 128 + int(s)
+		}
+
+		go func() {
+			<-signals
+
+			exit(code)
+		}()
+
+		quit(code)
+	}()
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+
+	b, err := cmd.Output()
+
+	return strings.TrimSpace(string(b)), err
+}
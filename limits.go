@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// watchLimitPath holds the maximum number of inotify watches per user on linux
+const watchLimitPath = "/proc/sys/fs/inotify/max_user_watches"
+
+// watchLimit returns the maximum number of directories that can be watched
+// for events, or 0 if there's no known limit
+func watchLimit() int {
+	b, err := os.ReadFile(watchLimitPath)
+	if err != nil {
+		return 0
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0
+	}
+
+	return n
+}
+
+// limitWarned is set once the watch limit warning has been printed
+var limitWarned bool
+
+// checkWatchLimit warns once if the number of watched directories gets close
+// to the system's watch limit
+// Polling isn't affected by the limit, but the same tree watched for events
+// would need a watch for each directory
+func checkWatchLimit(dirs, limit int) {
+	if limit == 0 || limitWarned || dirs < limit*8/10 {
+		return
+	}
+
+	logf(levelInfo, "watch: %v watched directories is close to the system limit of %v watches", dirs, limit)
+	logf(levelInfo, "watch: raise fs.inotify.max_user_watches with sysctl or skip more directories with -skip-patterns")
+
+	limitWarned = true
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Levels for watch's own messages, from the least to the most detailed
+const (
+	levelError = iota
+	levelInfo
+	levelDebug
+)
+
+var levelNames = [...]string{"error", "info", "debug"}
+
+// logLevel returns the most detailed level of message that gets printed
+// -quiet only leaves errors and -verbose adds debug messages
+func logLevel() int {
+	switch {
+	case opts.quiet:
+		return levelError
+
+	case opts.verbose:
+		return levelDebug
+
+	default:
+		return levelInfo
+	}
+}
+
+// logf prints one of watch's own messages as a line, or as a JSON log event
+// with -log-json, as long as its level is printed
+func logf(level int, format string, args ...any) {
+	if level > logLevel() {
+		return
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	if opts.logJSON {
+		logEvent(logLine{Event: "log", Time: time.Now(), Level: levelNames[level], Message: msg})
+
+		return
+	}
+
+	fmt.Fprintln(logOut, msg)
+}
+
+// logLine is a -log-json event that isn't about a run
+type logLine struct {
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Path    string    `json:"path,omitempty"`
+	Kind    string    `json:"kind,omitempty"`
+}
+
+// logMu keeps JSON log events from different goroutines on separate lines
+var logMu sync.Mutex
+
+// logEvent writes an event as a line of JSON
+func logEvent(v any) {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	json.NewEncoder(logOut).Encode(v)
+}
+
+// logChange writes a "changed" event with -log-json for a file that will be
+// passed to the next run
+func logChange(path, kind string) {
+	if opts.logJSON {
+		logEvent(logLine{Event: "changed", Time: time.Now(), Path: path, Kind: kind})
+	}
+}
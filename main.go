@@ -1,54 +1,337 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
-	"runtime"
+	"slices"
 	"strconv"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 	"unicode"
 )
 
 const defaultExts = ".asm .c .cc .cpp .csv .go .h .hh .hpp .json .rs .s .sql .v .vhdl .zig"
 
-var processes []*exec.Cmd
+// runners holds the command groups given on the command line
+// It's only replaced by the main loop, which holds runnersMu while it does so
+// that it can be read from other goroutines
+var (
+	runners   []*runner
+	runnersMu sync.Mutex
+)
+
+// tee mirrors command output when -tee is set
+var tee *teeWriter
+
+// remote is set when watching a directory on another machine
+var remote *remoteSource
 
 var lastRun time.Time
 
+// logOut receives all of the messages printed by watch itself so that they
+// don't get mixed in with the output of the commands being run
+var logOut = stderr
+
+// recent remembers watch's own recent lines when -clear-keep is set
+var recent *history
+
 var opts struct {
-	exts         string
-	patterns     string
-	skipDotDirs  bool
-	skipDotFiles bool
-	skipPatterns string
-	interval     time.Duration
-	verbose      bool
-	clear        bool
-	clearCmd     string
-	sigterm      bool
-	cmds         []string
+	config             string
+	dir                string
+	workdir            string
+	exts               string
+	patterns           string
+	skipDotDirs        bool
+	skipDotFiles       bool
+	skipPatterns       string
+	interval           time.Duration
+	adaptive           bool
+	maxInterval        time.Duration
+	verbose            bool
+	verbosity          int
+	quiet              bool
+	logJSON            bool
+	clear              bool
+	clearCmd           string
+	logStdout          bool
+	clearKeep          int
+	sigterm            bool
+	signal             string
+	killTimeout        time.Duration
+	watchGitHead       bool
+	format             string
+	requireAll         stringList
+	interactive        bool
+	waitForStable      bool
+	stableInterval     time.Duration
+	stableAttempts     int
+	summaryCmd         string
+	summaryVeto        bool
+	parallel           bool
+	keepGoing          bool
+	concurrency        int
+	remote             string
+	files              string
+	remoteExec         bool
+	snapshot           string
+	diff               string
+	json               bool
+	symlinkTargets     bool
+	followSymlinks     bool
+	maxDepth           int
+	minFilesChanged    int
+	bell               bool
+	notifyDesktop      bool
+	notifyOn           string
+	on                 string
+	tee                string
+	controlSocket      string
+	onChange           string
+	explain            bool
+	explainFile        string
+	activeHours        string
+	retryFailed        int
+	restart            bool
+	coalesce           bool
+	once               bool
+	each               bool
+	appendChanged      bool
+	list               bool
+	maxRuns            int
+	noStdin            bool
+	check              bool
+	shell              bool
+	prefix             bool
+	forceClear         bool
+	timestamps         bool
+	appendOnly         bool
+	commandsFromCmd    string
+	taskRunner         string
+	taskPrefix         string
+	commandsTrigger    string
+	triggerFile        string
+	serve              string
+	serveDir           string
+	http               string
+	changedSince       string
+	separator          string
+	drain              bool
+	modeMask           string
+	simulate           string
+	dryRun             bool
+	stats              bool
+	announceNextPoll   bool
+	contentChangesOnly bool
+	notify             bool
+	gitignore          bool
+	hash               bool
+	size               bool
+	hashLimit          int64
+	debounce           time.Duration
+	initialDelay       time.Duration
+	minRunInterval     time.Duration
+	noInitialRun       bool
+	cmds               []string
 }
 
 func main() {
+	flag.StringVar(&opts.dir, "dir", "", "The directory to watch instead of the current directory, which paths given to other flags are relative to")
+	flag.StringVar(&opts.workdir, "workdir", "", "The directory to run commands in, relative to the current directory, instead of the watched directory")
 	flag.StringVar(&opts.exts, "exts", defaultExts, "A space separated list of file extensions to watch")
 	flag.StringVar(&opts.patterns, "patterns", "", "A space separated list of patterns to watch")
 	flag.BoolVar(&opts.skipDotDirs, "skip-dot-dirs", true, "Whether to automatically skip any directories that begin with a dot")
 	flag.BoolVar(&opts.skipDotFiles, "skip-dot-files", false, "Whether to automatically skip any files that begin with a dot")
+	flag.IntVar(&opts.maxDepth, "max-depth", 0, "How many levels deep to watch, where 1 is only the files in the watched directory, 0 means no limit")
 	flag.StringVar(&opts.skipPatterns, "skip-patterns", "node_modules/*", "A space separated list of patterns to skip")
+	flag.BoolVar(&opts.gitignore, "gitignore", false, "Skip any paths ignored by .gitignore files, including nested ones")
 	flag.DurationVar(&opts.interval, "interval", 2*time.Second, "The interval to check for file changes")
-	flag.BoolVar(&opts.verbose, "verbose", false, "Print the commands that are about to be executed")
+	flag.BoolVar(&opts.adaptive, "adaptive", false, "Back off the interval while nothing changes, up to -max-interval, and return to -interval on a change")
+	flag.DurationVar(&opts.maxInterval, "max-interval", 30*time.Second, "The longest interval -adaptive backs off to")
+	flag.DurationVar(&opts.debounce, "debounce", 0, "How long files must stay unchanged after a change before running, 0 runs on the first scan that sees it")
+	flag.DurationVar(&opts.minRunInterval, "min-run-interval", 0, "The shortest time between the starts of two runs, with changes in between held back until it has passed")
+	flag.DurationVar(&opts.initialDelay, "initial-delay", 0, "How long to wait before the startup run, which happens straight away if a file changes first")
+	flag.BoolVar(&opts.noInitialRun, "no-initial-run", false, "Don't run the commands on startup, only when something changes")
+	flag.BoolVar(&opts.notify, "notify", false, "Scan for changes when the operating system reports them instead of on every interval")
+	flag.BoolVar(&opts.verbose, "verbose", false, "Print the commands that are about to be executed, the same as -verbosity 1")
+	flag.IntVar(&opts.verbosity, "verbosity", 0, "How much detail to print from 0 to 3, see the README for what each level adds")
+	flag.BoolVar(&opts.quiet, "quiet", false, "Only print watch's own errors, leaving the output of commands untouched")
+	flag.BoolVar(&opts.logJSON, "log-json", false, "Print watch's own messages, changed files, and runs starting and finishing as JSON lines")
 	flag.BoolVar(&opts.clear, "clear", false, "Clear the terminal before running commands")
 	flag.StringVar(&opts.clearCmd, "clear-cmd", "", "An optional command to run to clear the terminal")
-	flag.BoolVar(&opts.sigterm, "sigterm", false, "On linux/mac use SIGTERM instead of SIGKILL")
+	flag.BoolVar(&opts.forceClear, "force-clear", false, "Clear even when watch's own output, stderr unless -log-stdout is set, isn't a terminal, which is skipped by default")
+	flag.IntVar(&opts.clearKeep, "clear-keep", 0, "The number of watch's own most recent lines to print again after clearing the terminal")
+	flag.BoolVar(&opts.logStdout, "log-stdout", false, "Print watch's own messages to stdout instead of stderr")
+	flag.BoolVar(&opts.sigterm, "sigterm", false, "Ask commands to exit with SIGTERM, or taskkill without /f on windows, before forcing them to after -kill-timeout")
+	flag.StringVar(&opts.signal, "signal", "", "The signal to ask commands to exit with before forcing them to after -kill-timeout, such as SIGINT, or SIGHUP to ask running commands to reload instead of restarting them")
+	flag.DurationVar(&opts.killTimeout, "kill-timeout", 5*time.Second, "How long to wait for stopped commands to exit before forcing them to or giving up")
+	flag.BoolVar(&opts.watchGitHead, "watch-git-head", false, "Treat a git branch switch as a single change and run once the checkout settles")
+	flag.StringVar(&opts.format, "format", "", "A Go text/template used to print a status line after each run")
+	flag.BoolVar(&opts.interactive, "interactive", false, "Read single key commands from stdin, see the help line printed on startup")
+	flag.BoolVar(&opts.waitForStable, "wait-for-stable", false, "Only count a changed file once its size and modification time stop changing")
+	flag.DurationVar(&opts.stableInterval, "stable-interval", 100*time.Millisecond, "The delay between checks when waiting for a file to become stable")
+	flag.IntVar(&opts.stableAttempts, "stable-attempts", 10, "The number of checks to make before giving up on a file becoming stable until the next pass")
+	flag.StringVar(&opts.summaryCmd, "summary-cmd", "", "A command to run once per run with the change set as JSON on stdin")
+	flag.BoolVar(&opts.summaryVeto, "summary-veto", false, "Skip the commands when the summary command exits with a non-zero status")
+	flag.BoolVar(&opts.parallel, "parallel", false, "Start all commands at the same time instead of one after the other")
+	flag.BoolVar(&opts.shell, "shell", false, "Run each command with sh -c, or cmd /c on windows, instead of splitting it into arguments")
+	flag.BoolVar(&opts.noStdin, "no-stdin", false, "Don't give any command watch's stdin, for headless use such as CI")
+	flag.BoolVar(&opts.check, "check", false, "Check that the programs the commands run can be found and exit, with a non-zero status if any can't")
+	flag.BoolVar(&opts.each, "each", false, "Run commands with placeholders such as {file} once for each changed file instead of once with every file")
+	flag.BoolVar(&opts.appendChanged, "append-changed", false, "Add the changed files to the end of each command without placeholders, which then only run when files change")
+	flag.BoolVar(&opts.once, "once", false, "Run the commands once and exit with the exit code of the last one that failed")
+	flag.IntVar(&opts.maxRuns, "max-runs", 0, "The number of runs after which to exit once the last one finishes, 0 means no limit")
+	flag.BoolVar(&opts.coalesce, "coalesce", false, "Let a run finish when something changes during it, and then run once more, instead of restarting it")
+	flag.BoolVar(&opts.restart, "restart", false, "Run the commands again when the last one exits on its own, such as when a server crashes")
+	flag.BoolVar(&opts.prefix, "prefix", false, "Prefix each line of output with the command it came from, which -parallel always does")
+	flag.BoolVar(&opts.timestamps, "timestamps", false, "Prefix each line of output with the time it was written")
+	flag.BoolVar(&opts.keepGoing, "keep-going", false, "Carry on running the rest of the commands after one fails instead of stopping")
+	flag.IntVar(&opts.concurrency, "concurrency", 0, "The maximum number of commands to run at once in parallel mode, 0 means no limit")
+	flag.StringVar(&opts.files, "files", "", "A space separated list of files to check on each interval instead of walking the current directory")
+	flag.StringVar(&opts.remote, "remote", "", "Watch a directory on another machine over ssh given as user@host:path (experimental)")
+	flag.BoolVar(&opts.remoteExec, "remote-exec", false, "Run the commands on the remote machine in the watched directory (experimental)")
+	flag.StringVar(&opts.snapshot, "snapshot", "", "Write the watched files and their modification times to a file and exit")
+	flag.StringVar(&opts.diff, "diff", "", "Print the files added, modified, or deleted since a snapshot was written and exit")
+	flag.BoolVar(&opts.json, "json", false, "Print output as JSON where supported")
+	flag.BoolVar(&opts.followSymlinks, "follow-symlinks", false, "Walk into symlinks to directories, skipping any that lead somewhere already walked")
+	flag.BoolVar(&opts.symlinkTargets, "symlink-targets", false, "Track the files that symlinks point to rather than the links themselves")
+	flag.IntVar(&opts.minFilesChanged, "min-files-changed", 0, "The number of files that must change before running, changes are accumulated until it's reached")
+	flag.BoolVar(&opts.bell, "bell", false, "Ring the terminal bell when a run finishes")
+	flag.BoolVar(&opts.notifyDesktop, "notify-desktop", false, "Send a desktop notification with the result and duration when a run finishes")
+	flag.StringVar(&opts.on, "on", "", "A comma separated list of the kinds of change that cause a run: modify, create, delete")
+	flag.StringVar(&opts.notifyOn, "notify-on", "", "A comma separated list of status transitions to limit notifications to: break, recover")
+	flag.StringVar(&opts.tee, "tee", "", "A named pipe or unix socket to mirror command output to")
+	flag.StringVar(&opts.controlSocket, "control-socket", "", "A unix socket path to listen on for JSON-RPC control requests")
+	flag.StringVar(&opts.onChange, "on-change", "", "A script to run before each run that can skip it by exiting with a non-zero status")
+	flag.BoolVar(&opts.list, "list", false, "Print the files that would be watched and exit, along with why other paths are skipped with -verbose")
+	flag.BoolVar(&opts.explain, "explain", false, "Print the commands that would run if the file given to -explain-file changed and exit")
+	flag.StringVar(&opts.explainFile, "explain-file", "", "The file to use with -explain")
+	flag.StringVar(&opts.activeHours, "active-hours", "", "Only run on changes during these hours, for example \"Mon-Fri 09:00-17:30, Sat 10:00-12:00\"")
+	flag.IntVar(&opts.retryFailed, "retry-failed", 0, "After a failure, rerun only the failed commands on each change up to this many times before running every command again")
+	flag.BoolVar(&opts.appendOnly, "append-only", false, "Only count a file as changed when it grows, see WATCH_APPENDED for the appended bytes")
+	flag.StringVar(&opts.taskRunner, "task-runner", "make", "The program that commands using the task shorthand, such as make:build,test, run each target with")
+	flag.StringVar(&opts.taskPrefix, "task-prefix", "", "The prefix that starts the task shorthand for -task-runner, which defaults to its name followed by a colon")
+	flag.StringVar(&opts.commandsFromCmd, "commands-from-cmd", "", "A command to run at startup that prints more commands to run, one per line")
+	flag.StringVar(&opts.triggerFile, "trigger-file", "", "A file that runs every command when it's created or touched, whether or not it's watched")
+	flag.StringVar(&opts.commandsTrigger, "commands-trigger", "", "A file that makes watch run the -commands-from-cmd command again when it changes")
+	flag.StringVar(&opts.http, "http", "", "A port or address to serve GET /status and POST /trigger on, which is on localhost unless a host is given")
+	flag.StringVar(&opts.serve, "serve", "", "A port or address to serve files over HTTP on, which is on localhost unless a host is given, reloading pages after each successful run")
+	flag.StringVar(&opts.serveDir, "serve-dir", ".", "The directory to serve files from with -serve")
+	flag.StringVar(&opts.changedSince, "changed-since", "", "A git revision to compare against for the startup run, which then only sees the watched files that differ from it")
+	flag.StringVar(&opts.separator, "separator", "", "A line to print before each run, after clearing")
+	flag.BoolVar(&opts.drain, "drain", false, "Stop the previous run and wait for its output to finish before clearing or printing the separator")
+	flag.StringVar(&opts.modeMask, "mode-mask", "", "An octal permission mask, such as 0111, that also watches files with any of its bits set")
+	flag.StringVar(&opts.simulate, "simulate-changes", "", "A space separated list of files to treat as changed, running whatever that would run once and exiting")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "With -simulate-changes, print the commands that would run without running anything")
+	flag.BoolVar(&opts.stats, "stats", false, "Print the number of watched files and directories, and the system watch limit, whenever they change")
+	flag.BoolVar(&opts.announceNextPoll, "announce-next-poll", false, "Print when the next scan will happen while idle, needs -verbosity 2 or above")
+	flag.BoolVar(&opts.size, "size", false, "Count a change in a file's size as a change even if its modification time is the same")
+	flag.BoolVar(&opts.hash, "hash", false, "Detect changes by hashing file contents on every scan instead of using modification times")
+	flag.Int64Var(&opts.hashLimit, "hash-limit", 16<<20, "The size in bytes above which files aren't hashed and their modification times are used instead")
+	flag.BoolVar(&opts.contentChangesOnly, "content-changes-only", false, "Ignore modifications that don't change a file's content, such as a touch or chmod")
+	flag.Var(&opts.requireAll, "require-all", "A space separated list of patterns whose files must all change before running, can be repeated")
+	flag.StringVar(&opts.config, "config", "", "A config file to read settings and commands from (default \".watchrc\" if it exists)")
 	flag.Parse()
 
+	handleBrokenPipes()
+
+	// Commands on the command line replace any in the config file
+	commands, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "watch config error: %v\n", err)
+
+		os.Exit(2)
+	}
+	if flag.NArg() > 0 {
+		commands = flag.Args()
+	}
+
+	// The working directory for commands is relative to where watch was
+	// started, so it's resolved before moving to the watch root
+	if opts.workdir != "" {
+		dir, err := filepath.Abs(opts.workdir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "watch workdir error: %v\n", err)
+
+			os.Exit(1)
+		}
+
+		opts.workdir = dir
+	}
+
+	// Everything is relative to the watch root from here on, so the walk
+	// and patterns work the same way as when watch is started there
+	if opts.dir != "" {
+		if err := os.Chdir(opts.dir); err != nil {
+			fmt.Fprintf(os.Stderr, "watch dir error: %v\n", err)
+
+			os.Exit(1)
+		}
+	}
+
+	// -verbose is the first verbosity level, and everything that checks it
+	// is at that level
+	if opts.verbose && opts.verbosity < 1 {
+		opts.verbosity = 1
+	}
+	opts.verbose = opts.verbosity >= 1
+
+	if opts.quiet && opts.verbose {
+		fmt.Fprintln(os.Stderr, "watch quiet error: -quiet can't be used with -verbose or -verbosity")
+
+		os.Exit(2)
+	}
+
+	if opts.logStdout {
+		logOut = stdout
+	}
+
+	if opts.clearKeep > 0 {
+		recent = &history{w: logOut, max: opts.clearKeep}
+		logOut = recent
+	}
+
+	if err := setStopSignal(); err != nil {
+		logf(levelError, "watch signal error: %v", err)
+
+		os.Exit(1)
+	}
+
+	if err := parseOn(opts.on); err != nil {
+		logf(levelError, "watch on error: %v", err)
+
+		os.Exit(1)
+	}
+
+	if err := parseNotifyOn(opts.notifyOn); err != nil {
+		logf(levelError, "watch notify-on error: %v", err)
+
+		os.Exit(1)
+	}
+
+	if opts.format != "" {
+		t, err := parseFormat(opts.format)
+		if err != nil {
+			logf(levelError, "watch format error: %v (available fields: %v)", err, resultFields)
+
+			os.Exit(1)
+		}
+
+		statusFormat = t
+	}
+
+	hours, err := parseSchedule(opts.activeHours)
+	if err != nil {
+		logf(levelError, "watch active-hours error: %v", err)
+
+		os.Exit(1)
+	}
+
 	const defaultsPrefix = "+ "
 	if strings.HasPrefix(opts.exts, defaultsPrefix) {
 		opts.exts = strings.Replace(opts.exts, defaultsPrefix, defaultExts+" ", 1)
@@ -57,23 +340,41 @@ func main() {
 	opts.patterns = strings.TrimSpace(opts.patterns)
 	opts.skipPatterns = strings.TrimSpace(opts.skipPatterns)
 
-	var cmds []string
-	for _, str := range flag.Args() {
-		if strings.HasPrefix(str, "make:") {
-			str = strings.TrimPrefix(str, "make:")
+	r, err := loadRunners(commands)
+	if err != nil {
+		logf(levelError, "watch commands-from-cmd error: %v", err)
 
-			for _, str := range strings.Split(str, ",") {
-				str = strings.TrimSpace("make " + strings.TrimSpace(str))
+		os.Exit(1)
+	}
 
-				cmds = append(cmds, str)
-			}
-		} else {
-			cmds = append(cmds, str)
+	runnersMu.Lock()
+	runners = r
+	runnersMu.Unlock()
+
+	// Missing programs are only warned about unless -check asked for them
+	// to be checked, in which case nothing else happens
+	level := levelInfo
+	if opts.check {
+		level = levelError
+	}
+	errs := checkCommands(runners)
+	for _, err := range errs {
+		logf(level, "watch check: %v", err)
+	}
+	if opts.check {
+		if len(errs) > 0 {
+			os.Exit(1)
 		}
+
+		return
 	}
 
+	// Extensions starting with a dash are removed, such as "-.csv" to watch
+	// the defaults apart from csv files, and removals win wherever they are
 	exts := make(map[string]struct{})
+	var removed []string
 	for _, ext := range strings.Fields(opts.exts) {
+		ext, remove := strings.CutPrefix(ext, "-")
 		if ext == "" {
 			continue
 		}
@@ -82,187 +383,1193 @@ func main() {
 			ext = "." + ext
 		}
 
-		exts[ext] = struct{}{}
+		if remove {
+			removed = append(removed, ext)
+		} else {
+			exts[ext] = struct{}{}
+		}
+	}
+	for _, ext := range removed {
+		delete(exts, ext)
+	}
+
+	var modeMask fs.FileMode
+	if opts.modeMask != "" {
+		mask, err := strconv.ParseUint(opts.modeMask, 8, 32)
+		if err != nil || fs.FileMode(mask)&^fs.ModePerm != 0 {
+			logf(levelError, "watch mode-mask error: %q is not an octal permission mask", opts.modeMask)
+
+			os.Exit(1)
+		}
+
+		modeMask = fs.FileMode(mask)
+	}
+
+	var ignore *gitignore
+	if opts.gitignore {
+		ignore = newGitignore()
 	}
 
 	skipPatterns := strings.Fields(opts.skipPatterns)
 	watchPatterns := strings.Fields(opts.patterns)
-	skip := func(path string, entry fs.DirEntry) bool {
+
+	// The commands trigger is always watched so that changes to it are seen
+	commandsTrigger := filepath.Clean(opts.commandsTrigger)
+	if opts.commandsTrigger != "" {
+		watchPatterns = append(watchPatterns, filepath.ToSlash(commandsTrigger))
+	}
+
+	matchWatchPattern := func(path string) bool {
+		for _, pattern := range watchPatterns {
+			matched, err := matchPattern(pattern, path)
+			if err != nil {
+				logf(levelError, "watch pattern error: %v", err)
+			}
+			if matched {
+				return true
+			}
+		}
+
+		return false
+	}
+	var list watchList
+	if opts.files != "" {
+		if opts.remote != "" {
+			logf(levelError, "watch files error: -files can't be used with -remote")
+
+			os.Exit(2)
+		}
+
+		list = parseWatchList(opts.files)
+	}
+
+	// skipReason returns why the walk skips a path, or an empty string if
+	// the path is watched
+	skipReason := func(path string, entry fs.DirEntry) string {
 		if path == "." {
-			return true
+			return "the root directory"
+		}
+
+		// Only the listed files are watched with -files, whatever their
+		// name, but the directories leading to them mustn't be skipped
+		if list != nil {
+			if entry.IsDir() || list[filepath.Clean(path)] {
+				return ""
+			}
+
+			return "not in the -files list"
+		}
+
+		path = filepath.ToSlash(path)
+
+		// Files in the root are at a depth of 1, and directories at the
+		// maximum depth are skipped since nothing in them would be watched
+		if opts.maxDepth > 0 {
+			depth := strings.Count(path, "/") + 1
+			if depth > opts.maxDepth || (entry.IsDir() && depth >= opts.maxDepth) {
+				return fmt.Sprintf("deeper than -max-depth %v", opts.maxDepth)
+			}
 		}
 
 		if strings.HasPrefix(entry.Name(), ".") {
 			skipDir := entry.IsDir() && opts.skipDotDirs
 			skipFile := !entry.IsDir() && opts.skipDotFiles
 
-			if skipDir || skipFile {
-				return true
+			if (skipDir || skipFile) && !explicitDot(watchPatterns, path, entry.IsDir()) {
+				if skipDir {
+					return "dot directories are skipped"
+				}
+
+				return "dot files are skipped"
 			}
 		}
 
-		path = filepath.ToSlash(path)
-
 		for _, pattern := range skipPatterns {
-			matched, err := filepath.Match(pattern, path)
+			matched, err := matchPattern(pattern, path)
 			if err != nil {
-				fmt.Printf("watch skip pattern error: %v\n", err)
+				logf(levelError, "watch skip pattern error: %v", err)
 			}
 			if matched {
-				return true
+				return fmt.Sprintf("matches the skip pattern %q", pattern)
 			}
 		}
 
-		if _, ok := exts[filepath.Ext(path)]; !entry.IsDir() && !ok {
-			return true
+		if ignore != nil && ignore.ignored(path, entry.IsDir()) {
+			return "ignored by .gitignore"
 		}
 
-		for _, pattern := range watchPatterns {
-			matched, err := filepath.Match(pattern, path)
+		if entry.IsDir() {
+			return ""
+		}
+
+		// Files are watched if they have a watched extension, if they have
+		// any of the permission bits in the mode mask, or if they match any
+		// of the watch patterns
+		if _, ok := exts[filepath.Ext(path)]; ok {
+			return ""
+		}
+
+		if modeMask != 0 {
+			if fi, err := info(path, entry); err == nil && fi.Mode().IsRegular() && fi.Mode().Perm()&modeMask != 0 {
+				return ""
+			}
+		}
+
+		if matchWatchPattern(path) {
+			return ""
+		}
+
+		return "not a watched extension, mode, or pattern"
+	}
+	skip := func(path string, entry fs.DirEntry) bool {
+		return skipReason(path, entry) != ""
+	}
+
+	if opts.tee != "" {
+		tee = newTeeWriter(opts.tee)
+	}
+
+	if opts.remote != "" {
+		r, err := newRemoteSource(opts.remote)
+		if err != nil {
+			logf(levelError, "watch remote error: %v", err)
+
+			os.Exit(1)
+		}
+
+		remote = r
+	}
+
+	walk := func(fn fs.WalkDirFunc) error {
+		if remote != nil {
+			return remote.walk(fn)
+		}
+
+		if list != nil {
+			return list.walk(fn)
+		}
+
+		return walkDir(".", fn)
+	}
+
+	if opts.explain {
+		if opts.explainFile == "" {
+			logf(levelError, "watch explain error: -explain-file is required")
+
+			os.Exit(2)
+		}
+
+		explain(opts.explainFile, skip).print(stdout, opts.json)
+
+		return
+	}
+
+	if opts.list {
+		err := walk(func(path string, entry fs.DirEntry, err error) error {
 			if err != nil {
-				fmt.Printf("watch pattern error: %v\n", err)
+				return err
 			}
-			if matched {
-				return false
+
+			if reason := skipReason(path, entry); reason != "" {
+				if path != "." {
+					logf(levelDebug, "watch: skipping %v: %v", path, reason)
+				}
+
+				if entry.IsDir() && path != "." {
+					return filepath.SkipDir
+				}
+
+				return nil
 			}
+
+			if !entry.IsDir() {
+				fmt.Fprintln(stdout, path)
+			}
+
+			return nil
+		})
+		if err != nil {
+			logf(levelError, "watch list error: %v", err)
+
+			os.Exit(1)
 		}
 
-		return false
+		return
+	}
+
+	// Commands run in process groups of their own, so they have to be
+	// stopped by watch itself when it's interrupted, including in the runs
+	// that exit before watching starts
+	handleQuitSignals()
+
+	if opts.simulate != "" {
+		sim := simulate(strings.Fields(opts.simulate), walk, skip)
+		sim.print(stdout, opts.json)
+
+		if opts.dryRun || !sim.Run {
+			return
+		}
+
+		changed := sim.changedFiles()
+		if opts.onChange != "" {
+			if err := runHook(opts.onChange, changeSet{Trigger: "change", Files: changed}, "change"); err != nil {
+				logf(levelInfo, "watch: on-change script skipped the run: %v", err)
+
+				return
+			}
+		}
+
+		runAll(runners, "change", changed)
+
+		for _, r := range runners {
+			r.wait()
+		}
+
+		reportMu.Lock()
+		failed := lastStatus == "failed"
+		reportMu.Unlock()
+
+		if failed {
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if opts.once {
+		runAll(runners, "once", nil)
+		finish()
 	}
 
+	if opts.snapshot != "" || opts.diff != "" {
+		files, err := scan(walk, skip)
+		if err != nil {
+			logf(levelError, "watch scan error: %v", err)
+
+			os.Exit(1)
+		}
+
+		if opts.snapshot != "" {
+			if err := writeSnapshot(opts.snapshot, files); err != nil {
+				logf(levelError, "watch snapshot error: %v", err)
+
+				os.Exit(1)
+			}
+		}
+
+		if opts.diff != "" {
+			before, err := readSnapshot(opts.diff)
+			if err != nil {
+				logf(levelError, "watch diff error: %v", err)
+
+				os.Exit(1)
+			}
+
+			diffSnapshot(before, files).print(stdout, opts.json)
+		}
+
+		return
+	}
+
+	if opts.controlSocket != "" {
+		if err := listenControl(opts.controlSocket); err != nil {
+			logf(levelError, "watch control socket error: %v", err)
+
+			os.Exit(1)
+		}
+	}
+
+	if opts.http != "" {
+		if err := serveStatus(opts.http); err != nil {
+			logf(levelError, "watch http error: %v", err)
+
+			os.Exit(1)
+		}
+	}
+
+	if opts.serve != "" {
+		if err := serve(opts.serve, opts.serveDir); err != nil {
+			logf(levelError, "watch serve error: %v", err)
+
+			os.Exit(1)
+		}
+	}
+
+	if opts.interactive {
+		startInteractive()
+	}
+
+	var fsw *fsWatcher
+	if opts.notify {
+		if remote != nil {
+			logf(levelError, "watch notify error: notifications aren't supported with -remote, polling instead")
+		} else if w, err := newFSWatcher(skip, list.dirs()); err != nil {
+			logf(levelError, "watch notify error: %v, polling instead", err)
+		} else {
+			fsw = w
+		}
+	}
+
+	var scanned bool
 	var numFiles int
 	var lastNumFiles int
-	files := make(map[string]time.Time)
+	var debouncing bool
+	var lastChange time.Time
+	var delayingStartup bool
+	var throttled bool
+	started := time.Now()
+	var numDirs int
+	var lastNumDirs int
+	limit := watchLimit()
+	var paused bool
+	var held hold
+	var dirty bool
+	var forced bool
+	var trigFile *triggerFile
+	if opts.triggerFile != "" {
+		trigFile = &triggerFile{path: filepath.Clean(opts.triggerFile)}
+	}
+	var head gitHead
+	var switchedBranch bool
+	var changed []string
+	kinds := make(map[string]string)
+	files := make(map[string]fileState)
+	explained := make(map[string]bool)
+
+	// Errors are only printed for paths that could be read on the previous
+	// pass, so that a path that can't ever be read is only reported once
+	var lastWalkErrors map[string]bool
+	walkErrors := make(map[string]bool)
+	walkError := func(path string, err error) {
+		if !lastWalkErrors[path] {
+			logf(levelDebug, "watch: can't read %v, skipping it: %v", path, err)
+		}
+
+		walkErrors[path] = true
+	}
+	groups := newRequireGroups(opts.requireAll)
+	trigger := "startup"
+
+	// With -max-runs, watch exits once the last allowed run finishes
+	var runs int
+	counted := func() {
+		runs++
+
+		if opts.maxRuns > 0 && runs >= opts.maxRuns {
+			finish()
+		}
+	}
+
+	// The startup run can be given the files that differ from a git
+	// revision, otherwise it runs everything as usual
+	var sinceFiles []string
+	if opts.changedSince != "" {
+		since, err := changedSince(opts.changedSince)
+		if err != nil {
+			logf(levelError, "watch changed-since error: %v", err)
+		}
+
+		for _, path := range since {
+			if !skipped(path, skip) {
+				sinceFiles = append(sinceFiles, path)
+			}
+		}
+	}
+
+	// With -adaptive the interval grows while nothing is changing
+	interval := opts.interval
+
 	for {
 		var shouldRun bool
+		var active bool
+
+		for _, group := range groups {
+			group.files = make(map[string]time.Time)
+		}
+
+		// Git is checked before the walk as well as after it, since a
+		// checkout rewrites files before it moves HEAD
+		gitBusy := opts.watchGitHead && head.update()
 
-		_ = filepath.WalkDir(".", func(path string, entry fs.DirEntry, err error) error {
+		scanStart := time.Now()
+		present := make(map[string]bool)
+		var unreadable []string
+		var skippedDirs []string
+		skippedFiles := make(map[string]bool)
+		lastWalkErrors, walkErrors = walkErrors, make(map[string]bool)
+		err := walk(func(path string, entry fs.DirEntry, err error) error {
+			// A path that can't be read is reported and left as it was, so
+			// that one bad path doesn't stop the rest of the tree from being
+			// scanned or make it look deleted
+			// Paths that disappeared part way through, such as during a
+			// rename, are just gone
 			if err != nil {
-				return err
+				if path == "." {
+					return err
+				}
+
+				if !errors.Is(err, fs.ErrNotExist) {
+					walkError(path, err)
+
+					if entry != nil && entry.IsDir() {
+						unreadable = append(unreadable, path)
+					} else {
+						present[path] = true
+					}
+				}
+
+				if entry != nil && entry.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
 			}
 
-			if skip(path, entry) {
+			if reason := skipReason(path, entry); reason != "" {
+				// Skip reasons are only printed the first time a path is
+				// seen rather than on every pass
+				if opts.verbosity >= 3 && path != "." && !explained[path] {
+					logf(levelDebug, "watch: skipping %v: %v", path, reason)
+
+					explained[path] = true
+				}
+
 				// Completely skip directories
 				if entry.IsDir() && path != "." {
+					skippedDirs = append(skippedDirs, path)
+
 					return filepath.SkipDir
 				}
 
 				// Skip files individually
+				if _, ok := files[path]; ok {
+					skippedFiles[path] = true
+				}
+
 				return nil
 			}
 
-			fi, err := entry.Info()
+			if entry.IsDir() {
+				numDirs++
+			}
+
+			prev, seen := files[path]
+			present[path] = true
+
+			fi, err := info(path, entry)
 			if err != nil {
-				return err
+				if errors.Is(err, fs.ErrNotExist) {
+					delete(present, path)
+				} else {
+					walkError(path, err)
+				}
+
+				return nil
+			}
+			isModified := seen && prev.modTime.Before(fi.ModTime()) && lastRunFor(runners, path).Before(fi.ModTime())
+			isNew := !seen && scanned
+
+			// A change in size counts too with -size, which catches writes
+			// that keep the modification time, or set it back, without
+			// reading the file
+			if opts.size && seen && !fi.IsDir() && fi.Size() != prev.size {
+				isModified = true
+			}
+
+			// In append-only mode only growth counts as a change, and a file
+			// that shrinks, such as when it's truncated or rotated, is only
+			// tracked from its new size
+			if opts.appendOnly {
+				isModified = seen && !fi.IsDir() && fi.Size() > prev.size
+			}
+
+			if opts.waitForStable && remote == nil && (isModified || isNew) {
+				stable, ok := waitForStable(path, fi)
+				if !ok {
+					// The file is still being written so leave its state
+					// alone and pick it up again on the next pass
+					if seen {
+						numFiles++
+					}
+
+					return nil
+				}
+
+				fi = stable
+			}
+
+			cur := fileState{
+				modTime: fi.ModTime(),
+				size:    fi.Size(),
+				hash:    prev.hash,
+				hashed:  prev.hashed,
+				dir:     fi.IsDir(),
+			}
+
+			// With -hash, files small enough to hash are hashed on every pass
+			// and only count as modified if their content changed, whereas
+			// -content-changes-only only checks the hash of files that the
+			// modification time says have changed
+			// Files too big to hash fall back to the modification time
+			checkHash := opts.hash || (opts.contentChangesOnly && (isModified || !seen))
+
+			// A file whose size changed has changed content, so it isn't read
+			// just to find that out, which matters for a big log that keeps
+			// growing
+			// It's hashed instead on the first pass that finds it unchanged,
+			// so that later changes have a hash to be compared with
+			if !opts.hash && opts.contentChangesOnly && seen && !fi.IsDir() {
+				switch {
+				case isModified && fi.Size() != prev.size:
+					checkHash = false
+					cur.hash, cur.hashed = 0, false
+
+				case !isModified && !prev.hashed:
+					checkHash = true
+				}
+			}
+
+			if checkHash && !opts.appendOnly && remote == nil && !fi.IsDir() {
+				cur.hash, cur.hashed = hashFile(path, fi.Size())
+
+				if cur.hashed && prev.hashed {
+					isModified = cur.hash != prev.hash
+				}
 			}
 
 			numFiles++
 
-			if modified, ok := files[path]; !shouldRun && ok {
-				shouldRun = modified.Before(fi.ModTime()) && lastRun.Before(fi.ModTime())
+			// Kinds of change left out by -on are recorded in files like
+			// any other but don't count as changes
+			isModified = isModified && !isNew && counts(onModify)
+			isNew = isNew && counts(onCreate)
+
+			var grouped []*requireGroup
+			for _, group := range groups {
+				if group.match(filepath.ToSlash(path)) {
+					group.files[path] = fi.ModTime()
+					grouped = append(grouped, group)
+				}
+			}
+
+			// Changes accumulate across passes until the next run, so the
+			// same file may be seen more than once
+			if (isModified || isNew) && !fi.IsDir() && !slices.Contains(changed, path) {
+				changed = append(changed, path)
+
+				kinds[path] = "change"
+				if isNew {
+					kinds[path] = "new file"
+				}
+
+				logChange(path, kinds[path])
 			}
 
-			files[path] = fi.ModTime()
+			if isModified || isNew {
+				active = true
+
+				// Changes to files in a require-all group only count
+				// once every file in the group has been updated
+				for _, group := range grouped {
+					group.pending = true
+				}
+
+				shouldRun = shouldRun || len(grouped) == 0
+			}
+
+			if opts.appendOnly && (isModified || isNew) {
+				noteAppend(path, prev.size, fi.Size())
+			}
+
+			files[path] = cur
 
 			return nil
 		})
 
-		shouldRun = shouldRun || numFiles != lastNumFiles
+		if opts.verbosity >= 3 {
+			logf(levelDebug, "watch: scanned %v files in %v", numFiles, time.Since(scanStart).Round(time.Microsecond))
+		}
 
-		if shouldRun {
-			run(cmds)
+		// A failed remote listing says nothing about the files, so don't
+		// let it look like they were all deleted
+		if err != nil && remote != nil {
+			numFiles = lastNumFiles
 		}
 
-		lastNumFiles = numFiles
-		numFiles = 0
+		// Anything that wasn't seen on this pass has been deleted, unless
+		// the walk failed part way through
+		// Paths that are skipped now but weren't before, such as a file that
+		// no longer matches -mode-mask, are still there, so they're just no
+		// longer tracked rather than deleted
+		if err == nil {
+			var gone []string
+			for path := range files {
+				switch {
+				case present[path] || under(path, unreadable):
 
-		time.Sleep(opts.interval)
-	}
-}
+				case skippedFiles[path] || under(path, skippedDirs):
+					delete(files, path)
 
-func run(cmdStrs []string) {
-	lastRun = time.Now()
+				default:
+					gone = append(gone, path)
+				}
+			}
 
-	if opts.clear {
-		clear()
-	}
+			slices.Sort(gone)
 
-	// Kill any running processes
-	for _, cmd := range processes {
-		switch runtime.GOOS {
-		case "windows":
-			pid := strconv.Itoa(cmd.Process.Pid)
+			for _, path := range gone {
+				dir := files[path].dir
+				delete(files, path)
 
-			exec.Command("taskkill", "/t", "/f", "/pid", pid).Run()
+				if !counts(onDelete) {
+					continue
+				}
 
-		default:
-			if opts.sigterm {
-				cmd.Process.Signal(syscall.SIGTERM)
-			} else {
-				cmd.Process.Kill()
+				active = true
+				shouldRun = true
+
+				if dir {
+					continue
+				}
+
+				if !slices.Contains(changed, path) {
+					changed = append(changed, path)
+				}
+				kinds[path] = "deletion"
+
+				logChange(path, kinds[path])
 			}
 		}
-	}
 
-	processes = nil
+		// The first pass only records the files for later passes to compare
+		// against, and runs the commands on startup if it found any
+		if !scanned {
+			shouldRun = numFiles > 0
+			scanned = err == nil || remote == nil
+		}
+
+		if completeGroups(groups, lastRun) {
+			shouldRun = true
+		}
+
+		if trigger == "startup" {
+			switch {
+			case opts.noInitialRun:
+				shouldRun = false
+				trigger = "change"
+				sinceFiles = nil
 
-	// Rather than writing a parser for nested command line args we use this
-	// regular expression
-	// It should be fine for most use cases where it matches:
-	// - Escaped double quotes:  "(\\"|[^"])+"
-	// - Space separated values: [^\s\\]+
-	// - Escaped spaces:         (\\+\s[^\s\\]+)*
-	re := regexp.MustCompile(`"(\\"|[^"])+"|[^\s\\]+(\\+\s[^\s\\]+)*`)
+			case opts.initialDelay > 0:
+				// The startup run waits for the delay unless a change
+				// comes in first
+				delayingStartup = delayingStartup || shouldRun
+				shouldRun = delayingStartup && (len(changed) > 0 || time.Since(started) >= opts.initialDelay)
+				if shouldRun {
+					delayingStartup = false
+				}
+			}
+		}
 
-	// Run command strings
-	for i, cmdStr := range cmdStrs {
-		fields := re.FindAllString(cmdStr, -1)
-		for i := range fields {
-			fields[i] = strings.ReplaceAll(fields[i], `\ `, " ")
-			fields[i] = strings.ReplaceAll(fields[i], `\"`, `"`)
-			fields[i] = strings.ReplaceAll(fields[i], `\\`, `\`)
+		// Hold off until no new changes have been seen for the debounce
+		// duration, so that a burst of saves only causes one run
+		if opts.debounce > 0 && trigger != "startup" {
+			if shouldRun {
+				debouncing = true
+				lastChange = time.Now()
+			}
+
+			shouldRun = debouncing && time.Since(lastChange) >= opts.debounce
+			if shouldRun {
+				debouncing = false
+			}
 		}
 
-		program, args, message := command(fields[0], fields[1:]...)
+		// Hold off until the minimum interval since the last run has passed,
+		// running once then however many changes there were in between
+		if opts.minRunInterval > 0 && trigger != "startup" {
+			if shouldRun {
+				throttled = true
+			}
 
-		if opts.verbose {
-			fmt.Println(message)
+			shouldRun = throttled && time.Since(lastRun) >= opts.minRunInterval
+			if shouldRun {
+				throttled = false
+			}
 		}
 
-		cmd := exec.Command(program, args...)
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		// Hold off until enough files have changed, carrying the changes
+		// seen so far over to the next pass
+		if shouldRun && trigger != "startup" && len(changed) < opts.minFilesChanged {
+			shouldRun = false
+		}
 
-		processes = append(processes, cmd)
+		if opts.watchGitHead {
+			gitBusy = head.update() || gitBusy
 
-		if i == len(cmdStrs)-1 {
-			if err := cmd.Start(); err != nil {
-				fmt.Println(err)
+			// A checkout touches many files over several passes, so hold off
+			// from the first sign of git changing things until a pass where
+			// neither the tree nor git changed
+			// A branch switch then runs everything, and anything else, such
+			// as staging files, runs whatever the changes would have run
+			if head.settling {
+				if gitBusy || shouldRun {
+					shouldRun = false
+				} else {
+					shouldRun = head.switched || len(changed) > 0
+					switchedBranch = switchedBranch || head.switched
 
-				break
+					head.settling = false
+					head.switched = false
+				}
 			}
-		} else {
-			if err := cmd.Run(); err != nil {
-				fmt.Println(err)
+		}
+
+		// Touching the trigger file runs every command, even if nothing
+		// else changed or the changes so far wouldn't be enough to run
+		if trigFile != nil && trigFile.poll() {
+			active = true
+			shouldRun = true
+			forced = true
+		}
+
+		// With -coalesce, changes during a run don't interrupt it, and
+		// instead cause one more run once it has finished
+		if opts.coalesce {
+			busy := slices.ContainsFunc(runners, (*runner).running)
+			if shouldRun && busy {
+				dirty = true
+				shouldRun = false
+			} else if dirty && !busy {
+				shouldRun = true
+				dirty = false
+			}
+		}
+
+		// Changes outside the active hours are remembered but don't run
+		// anything until the window opens again
+		shouldRun = held.allow(shouldRun, !paused && hours.active(now()))
+
+		// The files from -changed-since are only added once the startup run
+		// is going ahead, so that they don't count as changes that cut the
+		// -initial-delay short
+		if shouldRun && trigger == "startup" {
+			for _, path := range sinceFiles {
+				if !slices.Contains(changed, path) {
+					changed = append(changed, path)
+					kinds[path] = "change"
+				}
+			}
+
+			sinceFiles = nil
+		}
+
+		if shouldRun && opts.onChange != "" {
+			if err := runHook(opts.onChange, changeSet{Trigger: trigger, Files: changed}, trigger); err != nil {
+				var exitErr *exec.ExitError
+				if !errors.As(err, &exitErr) {
+					logf(levelError, "watch on-change error: %v", err)
+				} else {
+					logf(levelDebug, "watch: on-change script skipped the run: %v", err)
+				}
+
+				shouldRun = false
+				changed = nil
+				kinds = make(map[string]string)
+				appended = make(map[string]appendRange)
+			}
+		}
+
+		if shouldRun {
+			if opts.commandsFromCmd != "" && slices.Contains(changed, commandsTrigger) {
+				refreshCommands(commands)
+			}
+
+			runTrigger := trigger
+			switch {
+			case forced:
+				runTrigger = "manual"
+
+				logf(levelDebug, "watch: running due to the trigger file %v", trigFile.path)
+
+			case switchedBranch:
+				runTrigger = "branch"
+
+				logf(levelDebug, "watch: running due to a branch switch")
+
+			case len(changed) > 0:
+				logf(levelDebug, "watch: running due to %v", describeChanges(changed, kinds))
+			}
+			if opts.verbosity >= 2 && len(changed) > 1 {
+				logf(levelDebug, "watch: changed: %v", listChanges(changed, kinds))
+			}
+
+			if runAll(runners, runTrigger, changed) {
+				counted()
+			}
+
+			trigger = "change"
+			forced = false
+			switchedBranch = false
+			changed = nil
+			kinds = make(map[string]string)
+			appended = make(map[string]appendRange)
+		}
+
+		state.Lock()
+		state.files = numFiles
+		state.Unlock()
 
-				break
+		// Directories are counted along with files, apart from the root
+		// which isn't walked as an entry of its own
+		watchedDirs := numDirs + 1
+		watchedFiles := numFiles - numDirs
+
+		if remote == nil {
+			checkWatchLimit(watchedDirs, limit)
+		}
+
+		if opts.stats && (numFiles != lastNumFiles || numDirs != lastNumDirs) {
+			stats := fmt.Sprintf("watch: stats: %v files, %v directories", watchedFiles, watchedDirs)
+			if limit > 0 && remote == nil {
+				stats += fmt.Sprintf(", %v of %v inotify watches", watchedDirs, limit)
+			}
+			logf(levelInfo, "%v", stats)
+		}
+
+		lastNumFiles = numFiles
+		lastNumDirs = numDirs
+		numFiles = 0
+		numDirs = 0
+
+		// Each pass without changes doubles the interval up to the maximum,
+		// and any change brings it straight back down
+		if opts.adaptive {
+			if active {
+				interval = opts.interval
+			} else {
+				interval = min(interval*2, max(opts.maxInterval, opts.interval))
+			}
+		}
+
+		// Announcements are only made while idle so they don't get mixed in
+		// with the output of a run
+		if opts.announceNextPoll && opts.verbosity >= 2 && fsw == nil && !slices.ContainsFunc(runners, (*runner).running) {
+			announceNextScan(interval, watchedFiles)
+		}
+
+		// With notifications the next scan happens when something changes
+		// rather than after the interval, unless some directories couldn't
+		// be watched
+		wait := interval
+		var wake <-chan struct{}
+		if fsw != nil {
+			wake = fsw.wake
+
+			if !fsw.partial.Load() {
+				wait = safetyScanInterval
+			}
+		}
+
+		// A pending debounce needs a scan as soon as the quiet period ends
+		if debouncing {
+			wait = max(0, min(wait, opts.debounce-time.Since(lastChange)))
+		}
+		if delayingStartup {
+			wait = max(0, min(wait, opts.initialDelay-time.Since(started)))
+		}
+		if throttled {
+			wait = max(0, min(wait, opts.minRunInterval-time.Since(lastRun)))
+		}
+
+		select {
+		case <-time.After(wait):
+
+		case <-wake:
+
+		case <-finished:
+
+		case act := <-actions:
+			switch act.kind {
+			case actionRun:
+				if act.index == 0 {
+					if runAll(runners, "manual", nil) {
+						counted()
+					}
+
+					break
+				}
+
+				// Commands are numbered group by group, in the order
+				// that the groups first appear
+				n := act.index
+				for _, r := range runners {
+					if n <= len(r.cmds) {
+						if opts.drain {
+							r.drain()
+						}
+
+						decorate()
+
+						r.run(r.cmds[n-1:n], "manual", nil, false)
+
+						break
+					}
+
+					n -= len(r.cmds)
+				}
+
+			case actionClear:
+				clear()
+
+			case actionRestart:
+				r := act.runner
+				if r.id.Load() == act.id && !paused && slices.Contains(runners, r) {
+					decorate()
+
+					r.run(r.cmds, "restart", nil, true)
+				}
+
+			case actionReload:
+				if flag.NArg() == 0 {
+					commands = act.commands
+					refreshCommands(commands)
+				}
+
+			case actionQuit:
+				quit(0)
+
+			case actionPause, actionResume, actionToggle:
+				wasPaused := paused
+
+				switch act.kind {
+				case actionPause:
+					paused = true
+				case actionResume:
+					paused = false
+				default:
+					paused = !paused
+				}
+
+				state.Lock()
+				state.paused = paused
+				state.Unlock()
+
+				if paused != wasPaused {
+					if paused {
+						logf(levelInfo, "watch: paused")
+					} else {
+						logf(levelInfo, "watch: resumed")
+					}
+				}
+
+				// Catch up on anything that changed while paused
+				if held.allow(false, !paused && hours.active(now())) {
+					if runAll(runners, "change", changed) {
+						counted()
+					}
+
+					changed = nil
+					kinds = make(map[string]string)
+					appended = make(map[string]appendRange)
+				}
+			}
+
+			if act.done != nil {
+				close(act.done)
 			}
 		}
 	}
 }
 
+// under reports whether path is one of the directories or inside one of them
+func under(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// describeChanges returns why a run is happening for the verbose output, such
+// as "change in main.go" or "3 changes"
+func describeChanges(changed []string, kinds map[string]string) string {
+	if len(changed) > 1 {
+		return fmt.Sprintf("%v changes", len(changed))
+	}
+
+	path := changed[0]
+	switch kinds[path] {
+	case "new file":
+		return "new file " + path
+
+	case "deletion":
+		return "deletion of " + path
+
+	default:
+		return "change in " + path
+	}
+}
+
+// listChanges returns the changed paths along with how each one changed
+func listChanges(changed []string, kinds map[string]string) string {
+	list := make([]string, len(changed))
+	for i, path := range changed {
+		list[i] = fmt.Sprintf("%v (%v)", path, kinds[path])
+	}
+
+	return strings.Join(list, ", ")
+}
+
+// info returns the file info for a walked entry
+// Entries are never followed by the walk, so for symlinks this is the info of
+// the link itself unless -symlink-targets is set
+func info(path string, entry fs.DirEntry) (fs.FileInfo, error) {
+	if opts.symlinkTargets && remote == nil && entry.Type()&fs.ModeSymlink != 0 {
+		if fi, err := os.Stat(path); err == nil {
+			return fi, nil
+		}
+	}
+
+	return entry.Info()
+}
+
 func clear() {
+	// Clearing goes straight to the terminal so that it isn't remembered
+	// as one of the lines to print again
+	out := logOut
+	var keep []string
+	if recent != nil {
+		out = recent.w
+		keep = recent.last()
+	}
+
+	// Clearing a file or pipe would only fill it with escape codes, so it's
+	// the output the codes go to that has to be a terminal, not stdout
+	if !opts.forceClear && !isTerminal(out) {
+		return
+	}
+
 	if opts.clearCmd != "" {
 		cmd := exec.Command(opts.clearCmd)
 		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
+		cmd.Stdout = out
 		cmd.Stderr = os.Stderr
 
 		cmd.Run()
 	} else {
-		fmt.Print("\033c")
+		fmt.Fprint(out, "\033c")
+	}
+
+	for _, line := range keep {
+		fmt.Fprintln(out, line)
+	}
+}
+
+// splitTargets splits the targets given to the task shorthand on commas so
+// that each target can have its own arguments
+// Commas inside quotes, or escaped with a backslash, don't split
+func splitTargets(str string) []string {
+	var targets []string
+	var start int
+	var quote rune
+	var escaped bool
+	for i, r := range str {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			}
+		case r == '\\':
+			escaped = true
+		case r == '"' || r == '\'':
+			if quote == 0 {
+				quote = r
+			} else if quote == r {
+				quote = 0
+			}
+		case r == ',' && quote == 0:
+			targets = append(targets, str[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(targets, str[start:])
+}
+
+// split breaks a command string into its program and arguments on spaces,
+// like a shell would
+// Single quotes keep everything inside them as it is, and double quotes only
+// unescape \" and \\ inside them
+// Outside of quotes, a backslash escapes a space, a quote, or a backslash,
+// and is kept as it is before anything else so that windows paths work
+// Quotes can start part way through an argument, as in --msg='a b', and are
+// removed wherever they are
+func split(cmdStr string) []string {
+	var fields []string
+	var field strings.Builder
+	var inField bool
+	var quote rune
+	runes := []rune(cmdStr)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		var next rune
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				field.WriteRune(r)
+			}
+
+		case quote == '"':
+			switch {
+			case r == '"':
+				quote = 0
+
+			case r == '\\' && (next == '"' || next == '\\'):
+				field.WriteRune(next)
+				i++
+
+			default:
+				field.WriteRune(r)
+			}
+
+		case unicode.IsSpace(r):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+
+		case r == '\'' || r == '"':
+			quote = r
+			inField = true
+
+		case r == '\\' && (unicode.IsSpace(next) || next == '"' || next == '\'' || next == '\\'):
+			field.WriteRune(next)
+			inField = true
+			i++
+
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
 	}
+
+	if inField {
+		fields = append(fields, field.String())
+	}
+
+	return fields
 }
 
 func command(program string, args ...string) (string, []string, string) {
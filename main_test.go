@@ -0,0 +1,636 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// The test binary runs as watch itself when started by startWatch, so that
+// the main loop can be tested with real scans
+func TestMain(m *testing.M) {
+	if os.Getenv("WATCH_TEST_MAIN") == "1" {
+		main()
+
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// watchProcess is watch running in a process of its own
+type watchProcess struct {
+	cmd *exec.Cmd
+
+	// exited is closed once watch has exited
+	exited chan struct{}
+
+	mu  sync.Mutex
+	out bytes.Buffer
+}
+
+func (w *watchProcess) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.out.Write(b)
+}
+
+// lines returns the lines of output so far that start with prefix
+func (w *watchProcess) lines(prefix string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var lines []string
+	for line := range strings.Lines(w.out.String()) {
+		if strings.HasPrefix(line, prefix) {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+
+	return lines
+}
+
+// startWatch starts watch in the current directory with a short interval and
+// waits for the startup run, which commands should mark by printing a line
+// starting with "ran"
+func startWatch(t *testing.T, args ...string) *watchProcess {
+	t.Helper()
+
+	w := launchWatch(t, args...)
+	waitFor(t, "the startup run", func() bool { return len(w.lines("ran")) == 1 })
+
+	return w
+}
+
+// launchWatch starts watch in the current directory with a short interval
+// without waiting for anything
+func launchWatch(t *testing.T, args ...string) *watchProcess {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands in the test need a unix shell")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &watchProcess{exited: make(chan struct{})}
+
+	cmd := exec.Command(exe, append([]string{"-interval", "20ms", "-no-stdin"}, args...)...)
+	cmd.Env = append(os.Environ(), "WATCH_TEST_MAIN=1")
+	cmd.Stdout = w
+	cmd.Stderr = w
+	cmd.WaitDelay = time.Second
+	w.cmd = cmd
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		cmd.Wait()
+		close(w.exited)
+	}()
+	t.Cleanup(func() {
+		w.stop()
+
+		if t.Failed() {
+			t.Logf("watch output:\n%s", w.out.Bytes())
+		}
+	})
+
+	return w
+}
+
+// stop stops watch the way Ctrl+C would, so that it stops its commands too,
+// and kills it if it doesn't exit
+func (w *watchProcess) stop() {
+	w.cmd.Process.Signal(os.Interrupt)
+
+	select {
+	case <-w.exited:
+
+	case <-time.After(10 * time.Second):
+		w.cmd.Process.Kill()
+		<-w.exited
+	}
+}
+
+// settle gives watch time for several passes
+func settle() {
+	time.Sleep(300 * time.Millisecond)
+}
+
+// edit appends a line to the file, creating it if needed
+// The modification time is set from the clock afterwards because file systems
+// can record a time slightly earlier than the write, which could be before
+// the last run started if the run was only just started
+func edit(t *testing.T, path string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString("// edited\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	modified := time.Now()
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		cmdStr string
+		want   []string
+	}{
+		{`go test ./...`, []string{"go", "test", "./..."}},
+		{`  go   vet  `, []string{"go", "vet"}},
+		{`foo 'a b' "c\"d" e\ f`, []string{"foo", "a b", `c"d`, "e f"}},
+		{`echo --msg='a b' --name="c d"`, []string{"echo", "--msg=a b", "--name=c d"}},
+		{`sh -c 'echo "hi"; echo \there'`, []string{"sh", "-c", `echo "hi"; echo \there`}},
+		{`echo "a \\ b" "c\d"`, []string{"echo", `a \ b`, `c\d`}},
+		{`echo a\"b a\'b a\\b`, []string{"echo", `a"b`, "a'b", `a\b`}},
+		{`dir C:\Users\me`, []string{"dir", `C:\Users\me`}},
+		{`echo '' ""`, []string{"echo", "", ""}},
+		{`echo 'a'"b"c`, []string{"echo", "abc"}},
+		{`make test ARGS="-run Foo,Bar"`, []string{"make", "test", "ARGS=-run Foo,Bar"}},
+		{``, nil},
+	}
+
+	for _, tt := range tests {
+		if got := split(tt.cmdStr); !slices.Equal(got, tt.want) {
+			t.Errorf("split(%q) = %q, want %q", tt.cmdStr, got, tt.want)
+		}
+	}
+}
+
+func TestSplitTargets(t *testing.T) {
+	tests := []struct {
+		str  string
+		want []string
+	}{
+		{"build,test", []string{"build", "test"}},
+		{`build ARGS=x, test ARGS="-run Foo,Bar"`, []string{"build ARGS=x", ` test ARGS="-run Foo,Bar"`}},
+		{`a 'x,y',b`, []string{"a 'x,y'", "b"}},
+	}
+
+	for _, tt := range tests {
+		if got := splitTargets(tt.str); !slices.Equal(got, tt.want) {
+			t.Errorf("splitTargets(%q) = %q, want %q", tt.str, got, tt.want)
+		}
+	}
+}
+
+func TestTargetArgs(t *testing.T) {
+	runners := newRunners([]string{`make:build ARGS=x, test ARGS="-run Foo,Bar"`})
+
+	want := [][]string{
+		{"make", "build", "ARGS=x"},
+		{"make", "test", "ARGS=-run Foo,Bar"},
+	}
+
+	if len(runners) != 1 || len(runners[0].cmds) != len(want) {
+		t.Fatalf("got runners %v, want one runner with %v commands", runners, len(want))
+	}
+
+	for i, cmdStr := range runners[0].cmds {
+		if got := split(cmdStr); !slices.Equal(got, want[i]) {
+			t.Errorf("command %v = %q, want %q", i+1, got, want[i])
+		}
+	}
+}
+
+// linkEntry returns the directory entry for the symlink at path
+func linkEntry(t *testing.T, path string) fs.DirEntry {
+	t.Helper()
+
+	entries, err := os.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		if entry.Name() == path {
+			return entry
+		}
+	}
+
+	t.Fatalf("no directory entry for %v", path)
+
+	return nil
+}
+
+func TestInfoSymlinkTarget(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Cleanup(func() { opts.symlinkTargets = false })
+
+	if err := os.WriteFile("target.go", []byte("package a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("target.go", "link.go"); err != nil {
+		t.Skipf("can't create symlinks: %v", err)
+	}
+
+	entry := linkEntry(t, "link.go")
+
+	opts.symlinkTargets = false
+	before, err := info("link.go", entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts.symlinkTargets = true
+	beforeTarget, err := info("link.go", entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Edit the target without touching the link, with a modification time
+	// that's clearly later even on file systems with coarse timestamps
+	if err := os.WriteFile("target.go", []byte("package a\n\nfunc f() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes("target.go", later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	afterTarget, err := info("link.go", entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if afterTarget.Size() == beforeTarget.Size() || !afterTarget.ModTime().After(beforeTarget.ModTime()) {
+		t.Errorf("with -symlink-targets, editing the target didn't change the link's info: size %v -> %v, modified %v -> %v", beforeTarget.Size(), afterTarget.Size(), beforeTarget.ModTime(), afterTarget.ModTime())
+	}
+
+	opts.symlinkTargets = false
+	after, err := info("link.go", entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if after.Mode()&fs.ModeSymlink == 0 {
+		t.Error("without -symlink-targets, the info isn't the link's own")
+	}
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("without -symlink-targets, editing the target changed the link's modification time from %v to %v", before.ModTime(), after.ModTime())
+	}
+}
+
+func TestMinFilesChangedAcrossPasses(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	for _, path := range []string{"a.go", "b.go", "c.go"} {
+		edit(t, path)
+	}
+
+	w := startWatch(t, "-min-files-changed", "3", "sh -c 'echo ran $WATCH_CHANGED_COUNT'")
+
+	// Each change is seen on a pass of its own, and they add up until there
+	// are enough of them
+	edit(t, "a.go")
+	settle()
+	edit(t, "b.go")
+	settle()
+	edit(t, "a.go")
+	settle()
+
+	if got := w.lines("ran"); len(got) != 1 {
+		t.Fatalf("ran %v times with 2 files changed, want only the startup run", len(got)-1)
+	}
+
+	edit(t, "c.go")
+	waitFor(t, "the run once 3 files changed", func() bool { return len(w.lines("ran")) == 2 })
+
+	if got := w.lines("ran")[1]; got != "ran 3" {
+		t.Errorf("the run saw %q, want the 3 files from every pass", got)
+	}
+
+	// The count starts again after a run
+	edit(t, "a.go")
+	settle()
+
+	if got := w.lines("ran"); len(got) != 2 {
+		t.Errorf("ran again with 1 file changed after the threshold was reached")
+	}
+}
+
+func TestModeMaskExecBit(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	for path, perm := range map[string]os.FileMode{"build": 0o755, "notes": 0o644} {
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"), perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := startWatch(t, "-exts", "", "-mode-mask", "0111", "sh -c 'echo ran $WATCH_CHANGED_FILES'")
+	runs := func() int { return len(w.lines("ran")) - 1 }
+
+	// Setting the exec bit starts watching the file
+	if err := os.Chmod("notes", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "a run for the file becoming executable", func() bool { return runs() == 1 })
+	if got := w.lines("ran")[1]; got != "ran notes" {
+		t.Errorf("the run saw %q, want the newly executable file", got)
+	}
+
+	edit(t, "notes")
+	waitFor(t, "a run for the executable file changing", func() bool { return runs() == 2 })
+
+	// Clearing it stops watching the file without a run
+	if err := os.Chmod("notes", 0o644); err != nil {
+		t.Fatal(err)
+	}
+	settle()
+	edit(t, "notes")
+	settle()
+
+	if got := runs(); got != 2 {
+		t.Errorf("ran %v times after the exec bit was cleared, want none", got-2)
+	}
+
+	// Files that are still executable are watched as before
+	edit(t, "build")
+	waitFor(t, "a run for the other executable changing", func() bool { return runs() == 3 })
+}
+
+func TestContentChangesOnlyTouch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	edit(t, "main.go")
+
+	w := startWatch(t, "-content-changes-only", "echo ran")
+	runs := func() int { return len(w.lines("ran")) - 1 }
+
+	// Touching the file updates its modification time but not its content
+	for range 3 {
+		touched := time.Now()
+		if err := os.Chtimes("main.go", touched, touched); err != nil {
+			t.Fatal(err)
+		}
+
+		settle()
+	}
+
+	if got := runs(); got != 0 {
+		t.Fatalf("touching a file ran %v times, want none", got)
+	}
+
+	edit(t, "main.go")
+	waitFor(t, "a run for the content changing", func() bool { return runs() >= 1 })
+
+	// The edit changes the size, so it isn't hashed until a pass finds it
+	// unchanged, and a pass between the write and setting the modification
+	// time can see the edit as two changes
+	settle()
+	edited := runs()
+
+	// The hash taken once the edit settled is the one later touches are
+	// compared with
+	touched := time.Now()
+	if err := os.Chtimes("main.go", touched, touched); err != nil {
+		t.Fatal(err)
+	}
+	settle()
+
+	if got := runs(); got != edited {
+		t.Errorf("touching a file after an edit ran %v times, want none", got-edited)
+	}
+}
+
+func TestSimulateFiltering(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("gen", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	edit(t, "main.go")
+	edit(t, "gen/a.go")
+	edit(t, "gen/b.go")
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-on", "delete", "-simulate-changes", "main.go"}, "nothing would run: none of the changes are in -on"},
+		{[]string{"-on", "delete", "-simulate-changes", "gone.go"}, "  1. echo ran"},
+		{[]string{"-require-all", "gen/*.go", "-simulate-changes", "gen/a.go"}, "nothing would run: not every file in a -require-all group changed"},
+		{[]string{"-require-all", "gen/*.go", "-simulate-changes", "gen/a.go gen/b.go"}, "  1. echo ran"},
+		{[]string{"-require-all", "gen/*.go", "-simulate-changes", "gen/a.go main.go"}, "  1. echo ran"},
+	}
+
+	for _, tt := range tests {
+		w := launchWatch(t, append(tt.args, "-dry-run", "echo ran")...)
+
+		select {
+		case <-w.exited:
+
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q didn't exit", tt.args)
+		}
+
+		if len(w.lines(tt.want)) == 0 {
+			t.Errorf("%q printed:\n%s\nwant %q", tt.args, w.out.Bytes(), tt.want)
+		}
+	}
+}
+
+// alive reports whether the process with the given pid is still running
+func alive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+func TestInterruptOnce(t *testing.T) {
+	for _, args := range [][]string{{"-once"}, {"-simulate-changes", "main.go"}} {
+		t.Run(args[0], func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			edit(t, "main.go")
+
+			// The command's own process is a shell waiting on a program
+			// it started, as with a wrapper script
+			w := startWatch(t, append(args, "sh -c 'sleep 30 & echo ran $!; wait'")...)
+
+			pid, err := strconv.Atoi(strings.TrimPrefix(w.lines("ran")[0], "ran "))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				if p, err := os.FindProcess(pid); err == nil {
+					p.Kill()
+				}
+			})
+
+			w.cmd.Process.Signal(os.Interrupt)
+
+			select {
+			case <-w.exited:
+
+			case <-time.After(5 * time.Second):
+				t.Fatal("watch didn't exit after being interrupted")
+			}
+
+			waitFor(t, "the command to be stopped", func() bool { return !alive(pid) })
+		})
+	}
+}
+
+func TestWatchGitHeadCheckout(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	// Only HEAD and the index are read, so a bare .git directory will do
+	if err := os.Mkdir(".git", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(".git/HEAD", []byte("ref: refs/heads/main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	edit(t, ".git/index")
+	edit(t, "main.go")
+	edit(t, "README.md")
+
+	w := startWatch(t, "-verbose", "-watch-git-head", "-exts", ".go .md", "[.go] echo ran go", "[.md] echo md")
+	waitFor(t, "the .md group's startup run", func() bool { return len(w.lines("md")) == 1 })
+
+	// Git rewrites the files with the index locked, and only moves HEAD and
+	// unlocks the index at the end
+	edit(t, ".git/index.lock")
+	edit(t, "main.go")
+	settle()
+	if err := os.WriteFile(".git/HEAD", []byte("ref: refs/heads/feature\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	settle()
+
+	if got := w.lines("ran"); len(got) != 1 {
+		t.Fatalf("ran %v times during the checkout, want none", len(got)-1)
+	}
+
+	edit(t, ".git/index")
+	if err := os.Remove(".git/index.lock"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The switch runs every group once, even those whose files didn't change
+	waitFor(t, "the run after the checkout", func() bool { return len(w.lines("md")) == 2 })
+	settle()
+
+	if got := w.lines("ran"); len(got) != 2 {
+		t.Errorf("the .go group ran %v times after the checkout, want 1", len(got)-1)
+	}
+	if got := w.lines("md"); len(got) != 2 {
+		t.Errorf("the .md group ran %v times after the checkout, want 1", len(got)-1)
+	}
+	if got := w.lines("watch: branch changed to feature"); len(got) != 1 {
+		t.Error("the branch change wasn't reported")
+	}
+
+	// Edits outside a checkout only run the groups they belong to
+	edit(t, "main.go")
+	waitFor(t, "the run for an edit", func() bool { return len(w.lines("ran")) == 3 })
+	settle()
+
+	if got := w.lines("md"); len(got) != 2 {
+		t.Errorf("an edit to a .go file ran the .md group")
+	}
+}
+
+// gitRepo sets up a repository in the current directory with the files
+// committed, skipping the test if git isn't installed
+func gitRepo(t *testing.T, files ...string) {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+
+	for _, path := range files {
+		edit(t, path)
+	}
+
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=watch", "-c", "user.email=watch@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args[0], err, out)
+		}
+	}
+}
+
+func TestChangedSinceStartup(t *testing.T) {
+	groups := []string{"-exts", ".go .md", "[.go] sh -c 'echo ran go $WATCH_CHANGED_FILES'", "[.md] sh -c 'echo ran md $WATCH_CHANGED_FILES'"}
+
+	t.Run("groups", func(t *testing.T) {
+		t.Chdir(t.TempDir())
+		gitRepo(t, "main.go", "README.md")
+		edit(t, "main.go")
+
+		w := startWatch(t, append([]string{"-changed-since", "HEAD"}, groups...)...)
+		settle()
+
+		want := []string{"ran go main.go"}
+		if got := w.lines("ran"); !slices.Equal(got, want) {
+			t.Errorf("the startup run gave %q, want %q", got, want)
+		}
+	})
+
+	t.Run("initial delay", func(t *testing.T) {
+		t.Chdir(t.TempDir())
+		gitRepo(t, "main.go", "README.md")
+		edit(t, "main.go")
+
+		start := time.Now()
+		startWatch(t, append([]string{"-changed-since", "HEAD", "-initial-delay", "500ms"}, groups...)...)
+
+		if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+			t.Errorf("the startup run happened after %v, want it to wait for -initial-delay", elapsed)
+		}
+	})
+
+	t.Run("no initial run", func(t *testing.T) {
+		t.Chdir(t.TempDir())
+		gitRepo(t, "main.go", "README.md")
+		edit(t, "main.go")
+
+		w := launchWatch(t, append([]string{"-changed-since", "HEAD", "-no-initial-run"}, groups...)...)
+		settle()
+
+		if got := w.lines("ran"); len(got) != 0 {
+			t.Fatalf("ran %q with -no-initial-run", got)
+		}
+
+		// The files from the revision went with the startup run
+		edit(t, "README.md")
+		waitFor(t, "a run for an edit", func() bool { return len(w.lines("ran")) == 1 })
+		settle()
+
+		want := []string{"ran md README.md"}
+		if got := w.lines("ran"); !slices.Equal(got, want) {
+			t.Errorf("the first change gave %q, want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// matchPattern reports whether a slash separated path matches the pattern
+//
+// Patterns use the same syntax as filepath.Match, where * doesn't match a
+// slash, with the addition of ** as a whole path element, which matches zero
+// or more elements, so "src/**/*.go" matches both "src/main.go" and
+// "src/a/b/main.go"
+func matchPattern(pattern, path string) (bool, error) {
+	if !strings.Contains(pattern, "**") {
+		return filepath.Match(pattern, path)
+	}
+
+	return matchElems(strings.Split(pattern, "/"), strings.Split(path, "/"))
+}
+
+func matchElems(pattern, elems []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+
+			// A trailing ** matches everything that's left
+			if len(pattern) == 0 {
+				return true, nil
+			}
+
+			for i := range len(elems) + 1 {
+				matched, err := matchElems(pattern, elems[i:])
+				if err != nil || matched {
+					return matched, err
+				}
+			}
+
+			return false, nil
+		}
+
+		if len(elems) == 0 {
+			return false, nil
+		}
+
+		matched, err := filepath.Match(pattern[0], elems[0])
+		if err != nil || !matched {
+			return false, err
+		}
+
+		pattern, elems = pattern[1:], elems[1:]
+	}
+
+	return len(elems) == 0, nil
+}
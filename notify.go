@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The status transitions that notifications can be limited to
+const (
+	edgeBreak   = "break"
+	edgeRecover = "recover"
+)
+
+// notifyEdges holds the transitions given to -notify-on
+// When empty every finished run is notified
+var notifyEdges = make(map[string]bool)
+
+func parseNotifyOn(value string) error {
+	for _, edge := range strings.Split(value, ",") {
+		edge = strings.TrimSpace(edge)
+
+		switch edge {
+		case "":
+			continue
+
+		case edgeBreak, edgeRecover:
+			notifyEdges[edge] = true
+
+		default:
+			return fmt.Errorf("unknown transition %q, expected %v or %v", edge, edgeBreak, edgeRecover)
+		}
+	}
+
+	return nil
+}
+
+// notify rings the terminal bell and sends a desktop notification for a
+// finished run if they're enabled and the run caused one of the transitions
+// given to -notify-on
+// A break is a failed run after a run that passed, or the first run failing,
+// and a recovery is a run that passed after a failed run
+func notify(res result, edge string) {
+	if len(notifyEdges) > 0 && !notifyEdges[edge] {
+		return
+	}
+
+	if opts.bell {
+		fmt.Fprint(logOut, "\a")
+	}
+
+	if opts.notifyDesktop && res.Status != "skipped" {
+		notifyDesktop(summarise(res))
+	}
+}
+
+// summarise describes a finished run for a desktop notification, for
+// example: "Failed with exit code 2 after 3.2s"
+func summarise(res result) string {
+	took := res.Duration.Round(100 * time.Millisecond)
+	if res.Status == "failed" {
+		return fmt.Sprintf("Failed with exit code %v after %v", res.ExitCode, took)
+	}
+
+	return fmt.Sprintf("Passed in %v", took)
+}
+
+// desktopFailed is set once a desktop notification has failed so that the
+// error is only printed once
+var desktopFailed atomic.Bool
+
+// toastScript shows a toast notification on windows using the app id of
+// PowerShell, since notifications need a registered app to come from
+const toastScript = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$t = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$t.GetElementsByTagName('text')[0].AppendChild($t.CreateTextNode('watch')) > $null
+$t.GetElementsByTagName('text')[1].AppendChild($t.CreateTextNode('%v')) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe').Show([Windows.UI.Notifications.ToastNotification]::new($t))`
+
+// notifyDesktop sends an operating system notification with notify-send on
+// linux, osascript on mac, or a PowerShell toast on windows
+// It doesn't wait for the notification to be shown
+func notifyDesktop(message string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", fmt.Sprintf(toastScript, message))
+
+	case "darwin":
+		cmd = exec.Command("osascript", "-e", fmt.Sprintf("display notification %q with title \"watch\"", message))
+
+	default:
+		cmd = exec.Command("notify-send", "watch", message)
+	}
+
+	go func() {
+		if err := cmd.Run(); err != nil && !desktopFailed.Swap(true) {
+			logf(levelError, "watch notify-desktop error: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The kinds of change that -on can limit runs to
+const (
+	onModify = "modify"
+	onCreate = "create"
+	onDelete = "delete"
+)
+
+// onEvents holds the kinds of change given to -on
+// When empty every kind of change counts
+var onEvents = make(map[string]bool)
+
+func parseOn(value string) error {
+	for _, kind := range strings.Split(value, ",") {
+		kind = strings.TrimSpace(kind)
+
+		switch kind {
+		case "":
+			continue
+
+		case onModify, onCreate, onDelete:
+			onEvents[kind] = true
+
+		default:
+			return fmt.Errorf("unknown change %q, expected %v, %v, or %v", kind, onModify, onCreate, onDelete)
+		}
+	}
+
+	return nil
+}
+
+// counts reports whether a kind of change should cause a run
+// Changes that don't are still recorded so they aren't seen again later
+func counts(kind string) bool {
+	return len(onEvents) == 0 || onEvents[kind]
+}
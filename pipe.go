@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// stdout and stderr are watch's own standard output and error, which stop
+// writing anything once whatever is reading them has gone away
+var (
+	stdout io.Writer = &pipeWriter{w: os.Stdout}
+	stderr io.Writer = &pipeWriter{w: os.Stderr}
+)
+
+// handleBrokenPipes stops watch from being killed by SIGPIPE when its output
+// is piped to a program that exits, so writes fail with EPIPE instead
+// Commands get the default SIGPIPE behaviour because signals that are handled
+// rather than ignored are reset when a command is started
+func handleBrokenPipes() {
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGPIPE)
+}
+
+// pipeWriter discards everything written to it after the first write that
+// fails because the reading end of the pipe was closed
+type pipeWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	broken bool
+}
+
+func (p *pipeWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.broken {
+		return len(b), nil
+	}
+
+	n, err := p.w.Write(b)
+	if errors.Is(err, syscall.EPIPE) {
+		p.broken = true
+
+		return len(b), nil
+	}
+
+	return n, err
+}
+
+// isTerminal reports whether w ends up writing to a terminal
+func isTerminal(w io.Writer) bool {
+	if p, ok := w.(*pipeWriter); ok {
+		w = p.w
+	}
+
+	f, ok := w.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPipeWriterClosedReader(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	p := &pipeWriter{w: w}
+
+	if _, err := p.Write([]byte("first\n")); err != nil {
+		t.Fatalf("writing to an open pipe failed: %v", err)
+	}
+
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil || line != "first\n" {
+		t.Fatalf("read %q, %v, want the first line", line, err)
+	}
+
+	r.Close()
+
+	// The write that finds the pipe closed and every write after it are
+	// discarded without an error
+	for i := range 3 {
+		n, err := p.Write([]byte("more\n"))
+		if err != nil || n != len("more\n") {
+			t.Errorf("write %v after the reader closed = %v, %v, want %v, nil", i+1, n, err, len("more\n"))
+		}
+	}
+
+	if !p.broken && runtime.GOOS != "windows" {
+		t.Error("the writer wasn't marked as broken after the reader closed")
+	}
+}
+
+func TestClosedOutputPipeMidRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands in the test need a unix shell")
+	}
+
+	t.Chdir(t.TempDir())
+	edit(t, "main.go")
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs := &watchProcess{}
+
+	// Watch's own messages go to stdout as well, so that they're written to
+	// the closed pipe along with the output of the commands
+	cmd := exec.Command(exe, "-interval", "20ms", "-no-stdin", "-verbose", "-log-stdout", "echo ran")
+	cmd.Env = append(os.Environ(), "WATCH_TEST_MAIN=1")
+	cmd.Stdout = w
+	cmd.Stderr = logs
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	var waitErr error
+	exited := make(chan struct{})
+	go func() {
+		waitErr = cmd.Wait()
+		close(exited)
+	}()
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		<-exited
+	})
+
+	out := bufio.NewReader(r)
+	for {
+		line, err := out.ReadString('\n')
+		if err != nil {
+			t.Fatalf("the startup run's output never arrived: %v", err)
+		}
+
+		if strings.TrimSpace(line) == "ran" {
+			break
+		}
+	}
+
+	r.Close()
+
+	// Runs after the reader has gone write to the closed pipe, both from the
+	// commands and from watch itself
+	for range 3 {
+		edit(t, "main.go")
+		settle()
+	}
+
+	select {
+	case <-exited:
+		t.Fatalf("watch exited after its output pipe was closed: %v\n%s", waitErr, logs.out.Bytes())
+
+	default:
+	}
+}
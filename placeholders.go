@@ -0,0 +1,165 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// placeholderRe matches the placeholders that are replaced in commands with
+// parts of the changed files' paths, for example: "gofmt -w {file}"
+var placeholderRe = regexp.MustCompile(`\{(file|dir|name|ext)\}`)
+
+func hasPlaceholders(cmdStr string) bool {
+	return placeholderRe.MatchString(cmdStr)
+}
+
+// needsFiles reports whether a command is given the changed files, either
+// through placeholders or by -append-changed adding them to the end
+func needsFiles(cmdStr string) bool {
+	return opts.appendChanged || hasPlaceholders(cmdStr)
+}
+
+// placeholderValue returns the part of path that a placeholder stands for
+func placeholderValue(placeholder, path string) string {
+	switch placeholder {
+	case "{dir}":
+		return filepath.Dir(path)
+
+	case "{name}":
+		return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	case "{ext}":
+		return filepath.Ext(path)
+
+	default:
+		return path
+	}
+}
+
+// expandPlaceholders returns the commands to run for a set of changed files
+// along with the files each command sees
+//
+// Commands that need files are only given the ones that still exist, and
+// can't run without any, so they're left out when there are none
+// With -each they run once for each file, and the rest run once with every
+// file
+func expandPlaceholders(cmdStrs, changed []string) ([]string, [][]string) {
+	var present []string
+	if slices.ContainsFunc(cmdStrs, needsFiles) {
+		present = existingFiles(changed)
+	}
+
+	var cmds []string
+	var files [][]string
+	for _, cmdStr := range cmdStrs {
+		if !needsFiles(cmdStr) {
+			cmds = append(cmds, cmdStr)
+			files = append(files, changed)
+
+			continue
+		}
+
+		if len(present) == 0 {
+			continue
+		}
+
+		if !opts.each {
+			cmds = append(cmds, cmdStr)
+			files = append(files, present)
+
+			continue
+		}
+
+		for _, path := range present {
+			cmds = append(cmds, cmdStr)
+			files = append(files, []string{path})
+		}
+	}
+
+	return cmds, files
+}
+
+// existingFiles returns the changed paths that are still there, leaving out
+// deleted files, which would make commands such as formatters fail
+// Remote paths can't be checked so they're all kept
+func existingFiles(changed []string) []string {
+	if remote != nil {
+		return changed
+	}
+
+	var files []string
+	for _, path := range changed {
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			files = append(files, path)
+		}
+	}
+
+	return files
+}
+
+// expandFields replaces the placeholders in a command's fields
+// A field with placeholders becomes one field for each file, without
+// duplicates, so that "go test ./{dir}" tests each changed directory once
+// Since fields are expanded after the command has been split, a path with
+// spaces in it stays a single argument
+// With -append-changed, commands without placeholders get the files added to
+// the end instead
+func expandFields(fields, files []string) []string {
+	if opts.appendChanged && !slices.ContainsFunc(fields, hasPlaceholders) {
+		return slices.Concat(fields, files)
+	}
+
+	var expanded []string
+	for _, field := range fields {
+		if !hasPlaceholders(field) {
+			expanded = append(expanded, field)
+
+			continue
+		}
+
+		var values []string
+		for _, path := range files {
+			value := placeholderRe.ReplaceAllStringFunc(field, func(placeholder string) string {
+				return placeholderValue(placeholder, path)
+			})
+
+			if !slices.Contains(values, value) {
+				values = append(values, value)
+			}
+		}
+
+		expanded = append(expanded, values...)
+	}
+
+	return expanded
+}
+
+// expandShell replaces the placeholders in a command given to the shell with
+// the quoted values for each file, separated by spaces
+// With -append-changed, commands without placeholders get the quoted files
+// added to the end instead
+func expandShell(cmdStr string, files []string) string {
+	if opts.appendChanged && !hasPlaceholders(cmdStr) {
+		for _, path := range files {
+			cmdStr += " " + shellQuoteArg(path)
+		}
+
+		return cmdStr
+	}
+
+	return placeholderRe.ReplaceAllStringFunc(cmdStr, func(placeholder string) string {
+		var values []string
+		for _, path := range files {
+			value := shellQuoteArg(placeholderValue(placeholder, path))
+
+			if !slices.Contains(values, value) {
+				values = append(values, value)
+			}
+		}
+
+		return strings.Join(values, " ")
+	})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExpandPlaceholdersSkipsDeletedFiles(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	if err := os.Mkdir("src", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("src", "a.go"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := []string{"src", filepath.Join("src", "a.go"), "deleted.go"}
+	cmds, files := expandPlaceholders([]string{"gofmt -w {file}", "go vet"}, changed)
+
+	if want := []string{"gofmt -w {file}", "go vet"}; !slices.Equal(cmds, want) {
+		t.Fatalf("commands = %q, want %q", cmds, want)
+	}
+	if want := []string{filepath.Join("src", "a.go")}; !slices.Equal(files[0], want) {
+		t.Errorf("files for a command with placeholders = %q, want %q", files[0], want)
+	}
+	if !slices.Equal(files[1], changed) {
+		t.Errorf("files for a command without placeholders = %q, want %q", files[1], changed)
+	}
+
+	// Nothing is left to give a command with placeholders after a deletion
+	cmds, _ = expandPlaceholders([]string{"gofmt -w {file}"}, []string{"deleted.go"})
+	if len(cmds) != 0 {
+		t.Errorf("commands = %q after only a deletion, want none", cmds)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+// timestampFormat is the layout of the time written before each line with
+// -timestamps
+const timestampFormat = "15:04:05.000 "
+
+// prefixWriter writes a prefix at the start of every line written through it
+// so that the output of commands running at the same time can be told apart
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+
+	// timestamps writes the time each line started before the prefix
+	timestamps bool
+
+	// midLine is set when the last write didn't end with a newline
+	midLine bool
+}
+
+func newPrefixWriter(w io.Writer, prefix string, timestamps bool) *prefixWriter {
+	return &prefixWriter{w: w, prefix: []byte(prefix), timestamps: timestamps}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	// Everything is written at once so that the prefix can't be separated
+	// from its line by another writer sharing the same output
+	var buf bytes.Buffer
+	for rest := b; len(rest) > 0; {
+		if !p.midLine {
+			if p.timestamps {
+				buf.WriteString(time.Now().Format(timestampFormat))
+			}
+
+			buf.Write(p.prefix)
+		}
+
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+
+		buf.Write(line)
+		rest = rest[len(line):]
+
+		p.midLine = line[len(line)-1] != '\n'
+	}
+
+	if _, err := p.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
@@ -0,0 +1,85 @@
+//go:build !windows
+
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// shellCommand returns a command that runs cmdStr with sh
+func shellCommand(cmdStr string) *exec.Cmd {
+	return exec.Command("sh", "-c", cmdStr)
+}
+
+// setProcessGroup starts the command in a process group of its own so that
+// signalCmd reaches the programs it starts too, such as a server started by a
+// wrapper script
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// signalCmd sends sig to the command's whole process group if it was started
+// in one of its own
+// Otherwise the processes it started are found and signalled one by one,
+// before the command itself so that none of them are orphaned first
+func signalCmd(cmd *exec.Cmd, sig syscall.Signal) error {
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.Setpgid {
+		return syscall.Kill(-cmd.Process.Pid, sig)
+	}
+
+	for _, pid := range descendants(cmd.Process.Pid) {
+		syscall.Kill(pid, sig)
+	}
+
+	return cmd.Process.Signal(sig)
+}
+
+// descendants returns the processes started by pid, and the ones they
+// started, using ps since not every system has /proc
+func descendants(pid int) []int {
+	out, err := exec.Command("ps", "-A", "-o", "pid=,ppid=").Output()
+	if err != nil {
+		return nil
+	}
+
+	children := make(map[int][]int)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		child, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+
+		parent, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+
+		children[parent] = append(children[parent], child)
+	}
+
+	var pids []int
+	queue := children[pid]
+	for len(queue) > 0 {
+		pids = append(pids, queue[0])
+		queue = append(queue[1:], children[queue[0]]...)
+	}
+
+	return pids
+}
+
+// shellQuoteArg quotes a string as a single argument for sh
+func shellQuoteArg(s string) string {
+	return shellQuote(s)
+}
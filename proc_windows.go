@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// shellCommand returns a command that runs cmdStr with cmd
+// The command line is given as is because cmd doesn't follow the quoting
+// rules that exec uses for arguments, and /s makes it remove only the outer
+// quotes
+func shellCommand(cmdStr string) *exec.Cmd {
+	cmd := exec.Command("cmd")
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: `cmd /s /c "` + cmdStr + `"`}
+
+	return cmd
+}
+
+// setProcessGroup does nothing on windows, where taskkill /t stops the
+// programs a command started along with it
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalCmd sends sig to the command's process
+// Process trees are stopped with taskkill on windows instead
+func signalCmd(cmd *exec.Cmd, sig syscall.Signal) error {
+	return cmd.Process.Signal(sig)
+}
+
+// shellQuoteArg quotes a string as a single argument for cmd
+func shellQuoteArg(s string) string {
+	return `"` + s + `"`
+}
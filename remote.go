@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// remoteSource lists the files in a directory on another machine over ssh so
+// that they can be run through the same walk as local files
+//
+// Each listing is a separate ssh invocation, so a dropped connection is just
+// retried on the next pass
+// On linux/mac the connection is shared between invocations using ssh's
+// connection multiplexing to keep each pass cheap
+type remoteSource struct {
+	host   string
+	dir    string
+	failed bool
+}
+
+func newRemoteSource(remote string) (*remoteSource, error) {
+	host, dir, ok := strings.Cut(remote, ":")
+	if !ok || host == "" {
+		return nil, fmt.Errorf("expected user@host:path but got %q", remote)
+	}
+
+	if dir == "" {
+		dir = "."
+	}
+
+	return &remoteSource{host: host, dir: dir}, nil
+}
+
+// sshArgs returns the arguments needed to run the given remote command
+func (r *remoteSource) sshArgs(extra ...string) []string {
+	args := []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=10", "-o", "ServerAliveInterval=5"}
+
+	if runtime.GOOS != "windows" {
+		control := filepath.Join(os.TempDir(), "watch-ssh-%C")
+
+		args = append(args, "-o", "ControlMaster=auto", "-o", "ControlPath="+control, "-o", "ControlPersist=60")
+	}
+
+	args = append(args, extra...)
+	args = append(args, r.host)
+
+	return args
+}
+
+// walk lists the remote directory and calls fn for each entry in the same
+// order and with the same SkipDir handling as filepath.WalkDir
+// Listing requires GNU find on the remote machine
+func (r *remoteSource) walk(fn fs.WalkDirFunc) error {
+	args := r.sshArgs()
+	args = append(args, "find", shellQuote(r.dir), "-printf", shellQuote(`%y %T@ %s %P\0`))
+
+	out, err := exec.Command("ssh", args...).Output()
+	if err != nil {
+		if !r.failed {
+			logf(levelError, "watch remote: %v, retrying", err)
+		}
+
+		r.failed = true
+
+		return err
+	}
+
+	if r.failed {
+		logf(levelInfo, "watch remote: reconnected")
+	}
+
+	r.failed = false
+
+	var skipDir string
+	for _, record := range strings.Split(string(out), "\x00") {
+		fields := strings.SplitN(record, " ", 4)
+		if len(fields) != 4 {
+			continue
+		}
+
+		kind, modified, size, name := fields[0], fields[1], fields[2], fields[3]
+		if name == "" {
+			name = "."
+		}
+
+		// Entries are listed depth first, so once an entry is outside of the
+		// skipped directory nothing else inside it will be seen
+		if skipDir != "" {
+			if strings.HasPrefix(name, skipDir+"/") {
+				continue
+			}
+
+			skipDir = ""
+		}
+
+		entry := &remoteEntry{
+			name:  path.Base(name),
+			isDir: kind == "d",
+		}
+		entry.size, _ = strconv.ParseInt(size, 10, 64)
+		if sec, frac, ok := strings.Cut(modified, "."); ok {
+			s, _ := strconv.ParseInt(sec, 10, 64)
+			ns, _ := strconv.ParseInt((frac + "000000000")[:9], 10, 64)
+
+			entry.modTime = time.Unix(s, ns)
+		}
+
+		if err := fn(name, entry, nil); err == filepath.SkipDir {
+			if entry.isDir {
+				skipDir = name
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// command wraps a program and its arguments so that it runs in the remote
+// directory over ssh
+// A terminal is forced so that the remote process is hung up when the local
+// ssh process is killed
+func (r *remoteSource) command(program string, args []string) (string, []string) {
+	quoted := make([]string, 0, len(args)+1)
+	quoted = append(quoted, shellQuote(program))
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+
+	script := "cd " + shellQuote(r.dir) + " && " + strings.Join(quoted, " ")
+
+	return "ssh", append(r.sshArgs("-t", "-t"), script)
+}
+
+// shellQuote quotes a string for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// remoteEntry is both the fs.DirEntry and fs.FileInfo of a remote file
+type remoteEntry struct {
+	name    string
+	isDir   bool
+	size    int64
+	modTime time.Time
+}
+
+func (e *remoteEntry) Name() string               { return e.name }
+func (e *remoteEntry) IsDir() bool                { return e.isDir }
+func (e *remoteEntry) Type() fs.FileMode          { return e.Mode().Type() }
+func (e *remoteEntry) Info() (fs.FileInfo, error) { return e, nil }
+func (e *remoteEntry) Size() int64                { return e.size }
+func (e *remoteEntry) ModTime() time.Time         { return e.modTime }
+func (e *remoteEntry) Sys() any                   { return nil }
+
+func (e *remoteEntry) Mode() fs.FileMode {
+	if e.isDir {
+		return fs.ModeDir | 0o755
+	}
+
+	return 0o644
+}
@@ -0,0 +1,1006 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// runner runs a group of commands and manages the processes they start
+//
+// Commands given without an extension filter all belong to one runner that
+// reacts to any change, while each distinct filter gets a runner of its own
+// Runners are independent, so a change that only affects one group doesn't
+// kill or wait on the commands of another
+type runner struct {
+	exts map[string]bool
+	cmds []string
+
+	// stdin is set on the last runner, whose last command is the only one
+	// that reads from watch's stdin
+	stdin bool
+
+	mu        sync.Mutex
+	processes []*process
+	cancel    chan struct{}
+
+	// killMu makes a kill wait for one that's already stopping processes,
+	// without holding mu while it waits for them to exit
+	killMu sync.Mutex
+
+	// env returns the variables that tell a run's commands what changed,
+	// with the paths relative to the directory a command runs in
+	env func(dir string) []string
+
+	// done is closed when the goroutine of the latest run finishes
+	done chan struct{}
+
+	// retry holds the commands that failed in the last run, which are run on
+	// the next change instead of the whole group when -retry-failed is set
+	retry   []string
+	retries int
+
+	// id is incremented each time the runner starts so that the results of
+	// runs that were interrupted by a newer run can be discarded
+	id atomic.Int64
+
+	// crashes counts the restarts in a row with -restart where the last
+	// command exited soon after starting, to back off between them
+	crashes int
+
+	// lastRun is when the runner last started, which is only used from the
+	// main loop
+	lastRun time.Time
+}
+
+const (
+	// restartBackoff is the delay before the first restart with -restart,
+	// which doubles with each quick crash up to maxRestartBackoff
+	restartBackoff    = 500 * time.Millisecond
+	maxRestartBackoff = 30 * time.Second
+
+	// restartStable is how long the last command must run for before
+	// exiting for the backoff to start again from restartBackoff
+	restartStable = 10 * time.Second
+)
+
+// groupRe matches the optional extension filter at the start of a command,
+// for example: "[.ts .tsx] npm run build"
+var groupRe = regexp.MustCompile(`^\[([^\]]*)\]\s*`)
+
+// cutTask splits a command using the task shorthand, such as
+// "make:build,test", into the task runner and its targets
+// The prefix for -task-runner defaults to its name followed by a colon, and
+// make: always works
+func cutTask(str string) (string, string, bool) {
+	prefix := cmp.Or(opts.taskPrefix, opts.taskRunner+":")
+	if targets, ok := strings.CutPrefix(str, prefix); ok {
+		return opts.taskRunner, targets, true
+	}
+
+	if targets, ok := strings.CutPrefix(str, "make:"); ok {
+		return "make", targets, true
+	}
+
+	return "", "", false
+}
+
+// newRunners groups command strings by their extension filters, expanding
+// the task shorthand along the way
+func newRunners(args []string) []*runner {
+	var runners []*runner
+	byFilter := make(map[string]*runner)
+	for _, str := range args {
+		var filter string
+		if m := groupRe.FindStringSubmatch(str); m != nil {
+			filter = strings.Join(strings.Fields(m[1]), " ")
+			str = str[len(m[0]):]
+		}
+
+		r, ok := byFilter[filter]
+		if !ok {
+			r = &runner{}
+			for _, ext := range strings.Fields(filter) {
+				if !strings.HasPrefix(ext, ".") {
+					ext = "." + ext
+				}
+
+				if r.exts == nil {
+					r.exts = make(map[string]bool)
+				}
+				r.exts[ext] = true
+			}
+
+			byFilter[filter] = r
+			runners = append(runners, r)
+		}
+
+		if program, targets, ok := cutTask(str); ok {
+			for _, str := range splitTargets(targets) {
+				str = strings.TrimSpace(program + " " + strings.TrimSpace(str))
+
+				r.cmds = append(r.cmds, str)
+			}
+		} else {
+			r.cmds = append(r.cmds, str)
+		}
+	}
+
+	if len(runners) > 0 {
+		runners[len(runners)-1].stdin = true
+	}
+
+	return runners
+}
+
+// affected returns the changed files that the runner cares about, and false
+// if the runner shouldn't run at all
+// A run not caused by a change, or a change where the files aren't known,
+// such as a deletion, affects every runner
+// The startup run with -changed-since is the exception, since its files are
+// the ones that differ from the revision
+func (r *runner) affected(trigger string, changed []string) ([]string, bool) {
+	byFiles := trigger == "change" || (trigger == "startup" && opts.changedSince != "")
+	if r.exts == nil || !byFiles || len(changed) == 0 {
+		return changed, true
+	}
+
+	var files []string
+	for _, path := range changed {
+		if r.exts[filepath.Ext(path)] {
+			files = append(files, path)
+		}
+	}
+
+	return files, len(files) > 0
+}
+
+// lastRunFor returns when the runners that a change to path would run last
+// started, going by the one that started longest ago, so that a change made
+// while another group was running still counts for the groups that haven't
+// seen it
+func lastRunFor(runners []*runner, path string) time.Time {
+	var last time.Time
+	first := true
+	for _, r := range runners {
+		if _, ok := r.affected("change", []string{path}); !ok {
+			continue
+		}
+
+		if first || r.lastRun.Before(last) {
+			last = r.lastRun
+			first = false
+		}
+	}
+
+	return last
+}
+
+// runAll runs every runner affected by the change and reports whether any
+// of them ran
+// Runners whose commands all need files, when none of the changed files
+// still exist, have nothing to run and are left alone
+func runAll(runners []*runner, trigger string, changed []string) bool {
+	var affected []*runner
+	var files [][]string
+	for _, r := range runners {
+		f, ok := r.affected(trigger, changed)
+		if !ok {
+			continue
+		}
+
+		if cmds, _ := expandPlaceholders(r.cmds, f); len(cmds) == 0 {
+			logf(levelDebug, "watch: no files for %q, not running it", r.cmds)
+
+			continue
+		}
+
+		affected = append(affected, r)
+		files = append(files, f)
+	}
+
+	if len(affected) == 0 {
+		return false
+	}
+
+	// The summary command sees the whole change set once per run, rather
+	// than each runner's share of it
+	if !summary(trigger, changed) {
+		report(result{Trigger: trigger, Files: changed, Status: "skipped"})
+
+		return true
+	}
+
+	if reloadSignal != 0 {
+		affected, files = signalReload(affected, files)
+		if len(affected) == 0 {
+			return true
+		}
+	}
+
+	if opts.drain {
+		for _, r := range affected {
+			r.drain()
+		}
+	}
+
+	decorate()
+
+	for i, r := range affected {
+		r.run(r.next(trigger), trigger, files[i], true)
+	}
+
+	return true
+}
+
+// signalReload sends the reload signal to the runners whose commands are still
+// running and returns the rest, along with their files, to be run as usual
+func signalReload(runners []*runner, files [][]string) ([]*runner, [][]string) {
+	var restRunners []*runner
+	var restFiles [][]string
+	for i, r := range runners {
+		if r.signalReload() {
+			continue
+		}
+
+		restRunners = append(restRunners, r)
+		restFiles = append(restFiles, files[i])
+	}
+
+	return restRunners, restFiles
+}
+
+// signalReload sends the reload signal to any processes still running from the
+// runner's previous run, and reports whether there were any
+func (r *runner) signalReload() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var reloaded bool
+	for _, p := range r.processes {
+		if p.cmd.Process == nil || cancelled(p.exited) {
+			continue
+		}
+
+		logf(levelInfo, "watch: asking process %v to reload", p.cmd.Process.Pid)
+
+		signalCmd(p.cmd, reloadSignal)
+
+		reloaded = true
+	}
+
+	return reloaded
+}
+
+// decorate clears the terminal and prints the separator before a run
+func decorate() {
+	if opts.clear {
+		clear()
+	}
+
+	if opts.separator != "" {
+		logf(levelInfo, "%v", opts.separator)
+	}
+}
+
+// drain kills the runner's previous run and waits for it to finish so that
+// none of its output can end up after the next run's clear or separator
+func (r *runner) drain() {
+	// The drained run is superseded so its result is ignored
+	r.id.Add(1)
+
+	r.kill()
+
+	r.mu.Lock()
+	done := r.done
+	r.mu.Unlock()
+
+	if done == nil {
+		return
+	}
+
+	select {
+	case <-done:
+
+	case <-time.After(opts.killTimeout):
+		logf(levelDebug, "watch: gave up waiting for the previous run to finish after %v", opts.killTimeout)
+	}
+}
+
+// next returns the commands to run for a trigger
+// After a failure with -retry-failed set, a change only reruns the commands
+// that failed, until they succeed or the retry limit is reached
+func (r *runner) next(trigger string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.retry) == 0 || trigger != "change" {
+		return r.cmds
+	}
+
+	if r.retries >= opts.retryFailed {
+		logf(levelDebug, "watch: giving up retrying %q after %v attempts, running every command", r.retry, r.retries)
+
+		r.retry = nil
+		r.retries = 0
+
+		return r.cmds
+	}
+
+	r.retries++
+
+	logf(levelDebug, "watch: retrying %q (attempt %v of %v)", r.retry, r.retries, opts.retryFailed)
+
+	return r.retry
+}
+
+// settle updates the retry state with the commands that failed in a run
+func (r *runner) settle(failed []string) {
+	if opts.retryFailed <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(failed) == 0 {
+		if len(r.retry) > 0 {
+			logf(levelDebug, "watch: %q succeeded, no longer retrying", r.retry)
+		}
+
+		r.retry = nil
+		r.retries = 0
+
+		return
+	}
+
+	// A new failure starts a fresh set of retries
+	if !slices.Equal(failed, r.retry) {
+		r.retries = 0
+	}
+
+	r.retry = failed
+
+	logf(levelDebug, "watch: %q failed, retrying on the next change", failed)
+}
+
+// run kills anything still running from the runner's previous run and then
+// starts the given commands in the background
+// Tracked runs update the retry state, which single commands run by hand
+// don't
+func (r *runner) run(cmdStrs []string, trigger string, changed []string, tracked bool) {
+	// Without any commands left to run there's no run to report, and what's
+	// still running from the last run is left alone
+	cmds, files := expandPlaceholders(cmdStrs, changed)
+	if len(cmds) == 0 {
+		logf(levelDebug, "watch: no files for %q, not running it", cmdStrs)
+
+		return
+	}
+
+	start := time.Now()
+	lastRun = start
+	r.lastRun = start
+
+	state.Lock()
+	state.lastRun = start
+	state.Unlock()
+
+	publish(event{
+		Event: "started",
+		Time:  start,
+		result: result{
+			Trigger:  trigger,
+			Files:    changed,
+			Commands: cmdStrs,
+		},
+	})
+
+	// Bump the id before killing so that the killed run's result is ignored
+	id := r.id.Add(1)
+
+	r.kill()
+
+	cancel := make(chan struct{})
+	done := make(chan struct{})
+
+	r.mu.Lock()
+	r.cancel = cancel
+	r.done = done
+	grown := appendedFiles(changed)
+	r.env = func(dir string) []string {
+		return append(changedEnv(relativePaths(dir, changed)), appendedEnv(dir, grown)...)
+	}
+	r.mu.Unlock()
+
+	go func() {
+		// The main loop is woken after done is closed so that it sees the
+		// run as finished
+		defer wakeFinished()
+		defer close(done)
+
+		res := result{
+			Trigger:  trigger,
+			Files:    changed,
+			Commands: cmdStrs,
+			Status:   "ok",
+		}
+
+		var failed []string
+		switch {
+		case opts.parallel:
+			failed, res.ExitCode = r.runParallel(cancel, cmds, files)
+
+		default:
+			failed, res.ExitCode = r.runSequential(cancel, cmds, files)
+		}
+
+		// With -each a command can fail for more than one file, but it
+		// only needs retrying once
+		failed = slices.Compact(failed)
+
+		if len(failed) > 0 {
+			res.Status = "failed"
+		}
+
+		res.Duration = time.Since(start)
+
+		// Ignore the results of runs that have since been superseded
+		if id == r.id.Load() {
+			if opts.verbosity >= 3 {
+				logf(levelDebug, "watch: run finished in %v: %v", res.Duration.Round(time.Millisecond), res.Status)
+			}
+
+			if tracked && res.Status != "skipped" {
+				r.settle(failed)
+			}
+
+			report(res)
+
+			if opts.restart && tracked && res.Status != "skipped" && !cancelled(cancel) && len(cmds) > 0 && reachedLast(cmds, failed) {
+				r.restart(id, res.Duration)
+			}
+		}
+	}()
+}
+
+// reachedLast reports whether the last command of a run was started, given
+// the commands that failed
+// Without -keep-going or -parallel, a sequential run stops at the first
+// failure, so the last command only ran if nothing before it failed
+func reachedLast(cmdStrs, failed []string) bool {
+	if opts.keepGoing || opts.parallel || len(failed) == 0 {
+		return true
+	}
+
+	return len(failed) == 1 && failed[0] == cmdStrs[len(cmdStrs)-1]
+}
+
+// restart runs the whole group again after the last command exited on its
+// own, as long as nothing else has run it in the meantime
+// Each quick exit doubles the delay before the next restart so that a
+// command that crashes on startup doesn't spin
+func (r *runner) restart(id int64, ran time.Duration) {
+	r.mu.Lock()
+	if ran >= restartStable {
+		r.crashes = 0
+	}
+	delay := min(restartBackoff<<r.crashes, maxRestartBackoff)
+	r.crashes = min(r.crashes+1, 16)
+	r.mu.Unlock()
+
+	logf(levelDebug, "watch: %v exited, restarting in %v", r.cmds[len(r.cmds)-1], delay)
+
+	time.AfterFunc(delay, func() {
+		actions <- action{kind: actionRestart, runner: r, id: id}
+	})
+}
+
+// finished receives a value when a run finishes so that the main loop can
+// start a run that was held back by -coalesce without waiting for a scan
+var finished = make(chan struct{}, 1)
+
+func wakeFinished() {
+	select {
+	case finished <- struct{}{}:
+	default:
+	}
+}
+
+// changedEnv returns the environment variables that tell commands which files
+// changed, one path per line in WATCH_CHANGED_FILES
+// Runs that weren't caused by a known change have no files and a count of 0
+func changedEnv(files []string) []string {
+	return []string{
+		"WATCH_CHANGED_FILES=" + strings.Join(files, "\n"),
+		"WATCH_CHANGED_COUNT=" + strconv.Itoa(len(files)),
+	}
+}
+
+// summary runs the summary command if there is one and reports whether the
+// run should go ahead
+func summary(trigger string, changed []string) bool {
+	if opts.summaryCmd == "" {
+		return true
+	}
+
+	if err := runHook(opts.summaryCmd, changeSet{Trigger: trigger, Files: changed}); err != nil {
+		logf(levelError, "watch summary command: %v", err)
+
+		return !opts.summaryVeto
+	}
+
+	return true
+}
+
+// runSequential runs each command in turn, stopping at the first failure
+// It returns the failed command along with the commands after it, or with
+// -keep-going, every command that failed, and the exit code of the last
+// command that failed
+func (r *runner) runSequential(cancel chan struct{}, cmdStrs []string, files [][]string) ([]string, int) {
+	var failed []string
+	var code int
+	for i, cmdStr := range cmdStrs {
+		var prefix string
+		if opts.prefix {
+			prefix = label(i, cmdStr)
+		}
+
+		p, err := r.start(cancel, cmdStr, files[i], prefix, i == len(cmdStrs)-1)
+		if p == nil {
+			break
+		}
+
+		if err == nil {
+			err = p.wait()
+		}
+
+		if err != nil {
+			code = exitCode(err)
+
+			if cancelled(cancel) {
+				return cmdStrs[i:], code
+			}
+
+			printCmdError(cmdStr, err)
+
+			if !opts.keepGoing {
+				return cmdStrs[i:], code
+			}
+
+			failed = append(failed, cmdStr)
+		}
+	}
+
+	return failed, code
+}
+
+// exitCode returns the exit code of a failed command, or 1 if it didn't exit
+// with one, such as when it couldn't be started or was killed by a signal
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+
+	return 1
+}
+
+// printCmdError prints why a command failed, using its exit code when it ran
+// and exited with one, so that a failing build can be told apart from a
+// program that couldn't be started
+func printCmdError(cmdStr string, err error) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		logf(levelError, "watch: %v exited with code %v", cmdStr, exitErr.ExitCode())
+
+		return
+	}
+
+	logf(levelError, "watch: %v failed: %v", cmdStr, err)
+}
+
+// runParallel starts all of the commands at once, or as many at once as the
+// -concurrency limit allows, queueing the rest until a running command exits
+// It returns the commands that failed in the order they were given, and the
+// exit code of the last of them
+func (r *runner) runParallel(cancel chan struct{}, cmdStrs []string, files [][]string) ([]string, int) {
+	limit := opts.concurrency
+	if limit <= 0 || limit > len(cmdStrs) {
+		limit = len(cmdStrs)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, limit)
+	codes := make([]int, len(cmdStrs))
+
+	setFailed := func(i int, err error) {
+		mu.Lock()
+		codes[i] = exitCode(err)
+		mu.Unlock()
+	}
+
+Queue:
+	for i, cmdStr := range cmdStrs {
+		select {
+		case slots <- struct{}{}:
+		case <-cancel:
+			break Queue
+		}
+
+		// The run may have been killed while waiting for a slot
+		p, err := r.start(cancel, cmdStr, files[i], label(i, cmdStr), i == len(cmdStrs)-1)
+		if p == nil {
+			break
+		}
+
+		if err != nil {
+			printCmdError(cmdStr, err)
+
+			setFailed(i, err)
+
+			<-slots
+
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := p.wait(); err != nil {
+				if !cancelled(cancel) {
+					printCmdError(cmdStr, err)
+				}
+
+				setFailed(i, err)
+			}
+
+			<-slots
+		}()
+	}
+
+	wg.Wait()
+
+	var failed []string
+	var code int
+	for i, cmdStr := range cmdStrs {
+		if codes[i] != 0 {
+			failed = append(failed, cmdStr)
+			code = codes[i]
+		}
+	}
+
+	return failed, code
+}
+
+// process is a started command along with a way to know when it has exited
+type process struct {
+	cmd    *exec.Cmd
+	cmdStr string
+
+	// exited is closed once the command has been waited on
+	exited chan struct{}
+}
+
+// wait waits for the command to exit
+func (p *process) wait() error {
+	defer close(p.exited)
+
+	return p.cmd.Wait()
+}
+
+// label returns the prefix for each line of output from a command running in
+// parallel, made from its position and program name, for example: "[2 go] "
+func label(i int, cmdStr string) string {
+	_, cmdStr = cutCmdEnv(cmdStr)
+	_, fields := cutStdin(split(cmdStr))
+	if len(fields) == 0 {
+		return fmt.Sprintf("[%v] ", i+1)
+	}
+
+	return fmt.Sprintf("[%v %v] ", i+1, filepath.Base(fields[0]))
+}
+
+// start starts a command unless the run has been cancelled, in which case it
+// returns a nil process
+// Checking for cancellation and starting happen under the same lock as kill
+// so a command can't be started after its run was killed
+// If a label is given, each line of the command's output is prefixed with it,
+// after the time if -timestamps is set
+// Only the last command of the last runner gets watch's stdin, so that a
+// long-running program at the end, such as a server or REPL, can read from
+// the terminal without the commands before it competing for the input
+func (r *runner) start(cancel chan struct{}, cmdStr string, files []string, label string, last bool) (*process, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cancelled(cancel) {
+		return nil, nil
+	}
+
+	cmd, err := newCmd(cmdStr, files, r.env, r.stdin && last)
+	p := &process{cmd: cmd, cmdStr: cmdStr, exited: make(chan struct{})}
+	if err != nil {
+		return p, err
+	}
+
+	if label != "" || opts.timestamps {
+		cmd.Stdout = newPrefixWriter(cmd.Stdout, label, opts.timestamps)
+		cmd.Stderr = newPrefixWriter(cmd.Stderr, label, opts.timestamps)
+	}
+
+	r.processes = append(r.processes, p)
+
+	return p, cmd.Start()
+}
+
+func cancelled(cancel chan struct{}) bool {
+	select {
+	case <-cancel:
+		return true
+
+	default:
+		return false
+	}
+}
+
+// stdinPrefix marks the first field of a command as a file to read the
+// command's stdin from, for example: "stdin:query.sql psql mydb"
+const stdinPrefix = "stdin:"
+
+// cutStdin removes a stdin: annotation from the start of a command's fields
+// and returns the path it names
+func cutStdin(fields []string) (string, []string) {
+	if len(fields) > 0 {
+		if path, ok := strings.CutPrefix(fields[0], stdinPrefix); ok {
+			return path, fields[1:]
+		}
+	}
+
+	return "", fields
+}
+
+// newCmd parses a command string and sets up the command to run it, with
+// any placeholders replaced using the given files and the variables from env
+// added to the environment
+// Paths are made relative to the directory the command runs in
+// With -shell the command string is given to the shell as is instead
+// The returned command is never started if there's an error
+func newCmd(cmdStr string, files []string, env func(string) []string, stdin bool) (*exec.Cmd, error) {
+	ce, cmdStr := cutCmdEnv(cmdStr)
+	dir := ce.workdir()
+	files = relativePaths(dir, files)
+
+	var cmd *exec.Cmd
+	var stdinFile string
+	if opts.shell {
+		if strings.TrimSpace(cmdStr) == "" {
+			return &exec.Cmd{}, fmt.Errorf("no command given in %q", cmdStr)
+		}
+
+		cmdStr = expandShell(cmdStr, files)
+
+		logf(levelDebug, "%v", cmdStr)
+
+		cmd = shellCommand(cmdStr)
+	} else {
+		var fields []string
+		stdinFile, fields = cutStdin(split(cmdStr))
+		fields = expandFields(fields, files)
+		if len(fields) == 0 {
+			return &exec.Cmd{}, fmt.Errorf("no command given in %q", cmdStr)
+		}
+
+		program, args, message := command(fields[0], fields[1:]...)
+		if stdinFile != "" {
+			message = strings.TrimSpace(message) + " < " + stdinFile
+		}
+
+		logf(levelDebug, "%v", message)
+
+		if remote != nil && opts.remoteExec {
+			program, args = remote.command(program, args)
+		}
+
+		cmd = exec.Command(program, args...)
+	}
+
+	// A command's own env= assignments come after the variables set by
+	// watch so that they take precedence
+	cmd.Dir = dir
+	if env != nil || len(ce.env) > 0 {
+		var vars []string
+		if env != nil {
+			vars = env(dir)
+		}
+
+		cmd.Env = slices.Concat(os.Environ(), vars, ce.env)
+	}
+
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if tee != nil {
+		// The standard streams are wrapped so that a closed pipe doesn't
+		// stop the output from being mirrored
+		cmd.Stdout = io.MultiWriter(stdout, tee)
+		cmd.Stderr = io.MultiWriter(stderr, tee)
+	}
+
+	// Commands without stdin read from the null device
+	// In interactive mode stdin is reserved for key presses
+	if stdin && !opts.noStdin && !opts.interactive {
+		cmd.Stdin = os.Stdin
+	}
+
+	// The file is read on every run so that changes to it are picked up
+	if stdinFile != "" {
+		b, err := os.ReadFile(stdinFile)
+		if err != nil {
+			return cmd, fmt.Errorf("stdin: %w", err)
+		}
+
+		cmd.Stdin = bytes.NewReader(b)
+	}
+
+	// Commands get process groups of their own so that stopping one stops
+	// everything it started as well
+	// A command reading the terminal has to stay in the terminal's process
+	// group to be allowed to read it, so signalCmd finds what it started
+	// instead
+	if cmd.Stdin != os.Stdin || !term.IsTerminal(int(os.Stdin.Fd())) {
+		setProcessGroup(cmd)
+	}
+
+	return cmd, nil
+}
+
+// kill stops any processes started by the runner's previous run, along with
+// any of its commands that haven't been started yet
+//
+// With -signal or -sigterm, processes are asked to exit and only forced to
+// if they're still running after -kill-timeout
+// Either way, kill waits for the processes to exit, up to the timeout, so
+// that a new run doesn't start while the old one is still holding on to
+// things like ports
+// The processes are taken under the lock, which is then released while they
+// exit, so that the status of the runner can still be read
+func (r *runner) kill() {
+	r.killMu.Lock()
+	defer r.killMu.Unlock()
+
+	r.mu.Lock()
+	if r.cancel != nil {
+		close(r.cancel)
+
+		r.cancel = nil
+	}
+
+	var live []*process
+	for _, p := range r.processes {
+		// Commands that failed to start have no process to kill
+		if p.cmd.Process != nil && !cancelled(p.exited) {
+			live = append(live, p)
+		}
+	}
+
+	r.processes = nil
+	r.mu.Unlock()
+
+	if len(live) == 0 {
+		return
+	}
+
+	for _, p := range live {
+		p.stop(stopSignal == syscall.SIGKILL)
+	}
+
+	if stopSignal != syscall.SIGKILL && !exited(live, opts.killTimeout) {
+		for _, p := range live {
+			if cancelled(p.exited) {
+				continue
+			}
+
+			logf(levelDebug, "watch: process %v didn't exit after %v, killing it", p.cmd.Process.Pid, opts.killTimeout)
+
+			p.stop(true)
+		}
+	}
+
+	exited(live, opts.killTimeout)
+}
+
+// stop asks the process to exit, or makes it exit if force is set
+func (p *process) stop(force bool) {
+	switch runtime.GOOS {
+	case "windows":
+		args := []string{"/t", "/pid", strconv.Itoa(p.cmd.Process.Pid)}
+		if force {
+			args = append(args, "/f")
+		}
+
+		exec.Command("taskkill", args...).Run()
+
+	default:
+		if force {
+			signalCmd(p.cmd, syscall.SIGKILL)
+		} else {
+			signalCmd(p.cmd, stopSignal)
+		}
+	}
+}
+
+// exited waits for all of the processes to exit, giving up after the timeout,
+// and reports whether they did
+func exited(processes []*process, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for _, p := range processes {
+		select {
+		case <-p.exited:
+		case <-deadline:
+			return false
+		}
+	}
+
+	return true
+}
+
+// running reports whether the runner's latest run is still going
+func (r *runner) running() bool {
+	r.mu.Lock()
+	done := r.done
+	r.mu.Unlock()
+
+	return done != nil && !cancelled(done)
+}
+
+// runningCommands returns the commands of the runner that are still running
+func (r *runner) runningCommands() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var cmds []string
+	for _, p := range r.processes {
+		if !cancelled(p.exited) {
+			cmds = append(cmds, p.cmdStr)
+		}
+	}
+
+	return cmds
+}
+
+// wait blocks until the runner's latest run has finished
+func (r *runner) wait() {
+	r.mu.Lock()
+	done := r.done
+	r.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+// killAll stops the processes of every runner
+func killAll(runners []*runner) {
+	for _, r := range runners {
+		r.kill()
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it's true, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGroupsRunIndependently(t *testing.T) {
+	opts.noStdin = true
+	t.Cleanup(func() { opts.noStdin = false })
+
+	runners := newRunners([]string{"[.go] sleep 5", "[.ts] true"})
+	backend, frontend := runners[0], runners[1]
+	t.Cleanup(func() { killAll(runners) })
+
+	runAll(runners, "change", []string{"main.go"})
+	waitFor(t, "the .go group to start", backend.running)
+
+	// A .ts change runs the .ts group without touching the .go group
+	runAll(runners, "change", []string{"app.ts"})
+	waitFor(t, "the .ts group to finish", func() bool {
+		return frontend.lastRun.After(backend.lastRun) && !frontend.running()
+	})
+
+	if !backend.running() {
+		t.Fatal("the .go group was stopped by a .ts change")
+	}
+
+	// Another .go change restarts only the .go group
+	id := backend.id.Load()
+	frontendRun := frontend.lastRun
+
+	runAll(runners, "change", []string{"other.go"})
+
+	if got := backend.id.Load(); got != id+1 {
+		t.Errorf("the .go group ran %v times after a .go change, want 1", got-id)
+	}
+	if !frontend.lastRun.Equal(frontendRun) {
+		t.Error("the .ts group ran after a .go change")
+	}
+}
+
+func TestNothingToRun(t *testing.T) {
+	opts.noStdin = true
+	t.Cleanup(func() { opts.noStdin = false })
+
+	// Runs from earlier tests report in the background
+	reportMu.Lock()
+	prevStatus := lastStatus
+	lastStatus = "failed"
+	reportMu.Unlock()
+	t.Cleanup(func() {
+		reportMu.Lock()
+		lastStatus = prevStatus
+		reportMu.Unlock()
+	})
+
+	runners := newRunners([]string{"gofmt -l {file}"})
+	t.Cleanup(func() { killAll(runners) })
+
+	// Neither the startup run nor a deletion leaves any files to format
+	for _, change := range []struct {
+		trigger string
+		changed []string
+	}{
+		{"startup", nil},
+		{"change", []string{"deleted.go"}},
+	} {
+		if runAll(runners, change.trigger, change.changed) {
+			t.Errorf("a %v run of %q reported running", change.trigger, change.changed)
+		}
+	}
+
+	// A run that reported as ok would have passed a build that's broken
+	reportMu.Lock()
+	status := lastStatus
+	reportMu.Unlock()
+
+	if !runners[0].lastRun.IsZero() || status != "failed" {
+		t.Errorf("a run without any commands ran, status %q", status)
+	}
+}
+
+func TestStatusWhileKilling(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the command in the test needs a unix shell")
+	}
+
+	opts.noStdin = true
+	prevTimeout, prevSignal := opts.killTimeout, stopSignal
+	opts.killTimeout = 2 * time.Second
+	stopSignal = syscall.SIGTERM
+	t.Cleanup(func() {
+		opts.noStdin = false
+		opts.killTimeout, stopSignal = prevTimeout, prevSignal
+	})
+
+	// The command ignores SIGTERM, so stopping it takes the whole timeout
+	runners := newRunners([]string{`sh -c "trap '' TERM; sleep 5"`})
+	r := runners[0]
+	t.Cleanup(func() { killAll(runners) })
+
+	runAll(runners, "change", []string{"main.go"})
+	waitFor(t, "the command to start", func() bool { return len(r.runningCommands()) == 1 })
+
+	// Give the shell time to set up the trap before it's signalled
+	time.Sleep(100 * time.Millisecond)
+
+	killed := make(chan struct{})
+	go func() {
+		r.kill()
+		close(killed)
+	}()
+
+	statused := make(chan struct{})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		r.runningCommands()
+		close(statused)
+	}()
+
+	select {
+	case <-statused:
+
+	case <-killed:
+		t.Fatal("the command was stopped before the kill timeout")
+
+	case <-time.After(time.Second):
+		t.Fatal("reading the running commands waited for the kill")
+	}
+
+	<-killed
+}
+
+func TestLastRunFor(t *testing.T) {
+	runners := newRunners([]string{"[.go] go build", "[.ts] npm run build", "echo any"})
+	backend, frontend, catchAll := runners[0], runners[1], runners[2]
+
+	start := time.Now()
+	backend.lastRun = start
+	frontend.lastRun = start.Add(2 * time.Second)
+	catchAll.lastRun = start.Add(3 * time.Second)
+
+	// A .go file saved while the .ts group was starting is still newer than
+	// the .go group's last run, even though it's older than the latest run
+	saved := start.Add(time.Second)
+	if !lastRunFor(runners, "main.go").Before(saved) {
+		t.Error("a .go change made after the .go group started didn't count")
+	}
+	if lastRunFor(runners, "app.ts").Before(saved) {
+		t.Error("a .ts change made before the .ts group started counted")
+	}
+
+	if got := lastRunFor(runners, "README.md"); !got.Equal(catchAll.lastRun) {
+		t.Errorf("lastRunFor(README.md) = %v, want the catch-all group's %v", got, catchAll.lastRun)
+	}
+}
+
+func TestDrainBeforeSeparator(t *testing.T) {
+	t.Chdir(t.TempDir())
+	edit(t, "main.go")
+
+	// The server keeps printing until it's stopped, and prints a last line
+	// as it exits
+	w := startWatch(t, "-drain", "-sigterm", "-separator", "----",
+		`sh -c 'trap "echo last; exit 0" TERM; echo ran; while :; do echo tick; sleep 0.01; done'`)
+
+	edit(t, "main.go")
+	waitFor(t, "the second run", func() bool { return len(w.lines("ran")) == 2 })
+
+	var lines []string
+	for _, line := range w.lines("") {
+		if line == "----" || line == "ran" || line == "tick" || line == "last" {
+			lines = append(lines, line)
+		}
+	}
+
+	// The previous run's output, up to its last line, all comes before the
+	// separator for the next run
+	second := slices.Index(lines[1:], "----") + 1
+	if second == 0 {
+		t.Fatalf("no separator before the second run in %q", lines)
+	}
+	if lines[second-1] != "last" {
+		t.Errorf("the separator came after %q, want the previous run's last line", lines[second-1])
+	}
+	if next := lines[second+1]; next != "ran" {
+		t.Errorf("the separator was followed by %q, want the next run", next)
+	}
+}
+
+func TestStdinFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	if err := os.WriteFile("seed.sql", []byte("select 1;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdinOf := func() (*exec.Cmd, string) {
+		t.Helper()
+
+		cmd, err := newCmd("stdin:seed.sql psql -d app", nil, nil, true)
+		if err != nil {
+			t.Fatalf("newCmd failed: %v", err)
+		}
+
+		b, err := io.ReadAll(cmd.Stdin)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return cmd, string(b)
+	}
+
+	cmd, got := stdinOf()
+	if want := []string{"psql", "-d", "app"}; !slices.Equal(cmd.Args, want) {
+		t.Errorf("got args %q, want %q without the stdin file", cmd.Args, want)
+	}
+	if got != "select 1;\n" {
+		t.Errorf("got stdin %q, want the file's contents", got)
+	}
+
+	// The file is read again for each run
+	if err := os.WriteFile("seed.sql", []byte("select 2;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, got := stdinOf(); got != "select 2;\n" {
+		t.Errorf("got stdin %q after editing the file, want the new contents", got)
+	}
+
+	// A missing file fails the command rather than running it without input
+	if _, err := newCmd("stdin:missing.sql psql", nil, nil, true); err == nil || !strings.HasPrefix(err.Error(), "stdin:") {
+		t.Errorf("newCmd with a missing stdin file gave %v, want a stdin error", err)
+	}
+}
+
+func TestStdinFileRun(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	if err := os.WriteFile("input.txt", []byte("ran with the first input\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	edit(t, "main.go")
+
+	w := startWatch(t, "stdin:input.txt cat", "stdin:missing.txt cat", "echo after")
+
+	if err := os.WriteFile("input.txt", []byte("ran with the second input\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	edit(t, "main.go")
+	waitFor(t, "the second run", func() bool { return len(w.lines("ran")) == 2 })
+
+	want := []string{"ran with the first input", "ran with the second input"}
+	if got := w.lines("ran"); !slices.Equal(got, want) {
+		t.Errorf("the command read %q, want %q", got, want)
+	}
+
+	// Only the command with the missing file fails, which stops the run
+	// before the commands after it as any failure would
+	if got := w.lines("watch: stdin:missing.txt cat failed: stdin:"); len(got) == 0 {
+		t.Error("no error for the missing stdin file")
+	}
+	if got := w.lines("after"); len(got) != 0 {
+		t.Errorf("the commands after the failed one ran %v times", len(got))
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// reloadPath is where served pages listen for reloads
+const reloadPath = "/__watch/reload"
+
+// reloadClient is injected into served HTML pages so that they reload once a
+// run finishes successfully
+const reloadClient = `<script>new EventSource("` + reloadPath + `").addEventListener("reload", () => location.reload())</script>`
+
+// listenAddr turns a port or address given to a flag into an address to
+// listen on, which is on localhost unless a host is given, so that nothing is
+// exposed to the network without asking for it
+func listenAddr(addr string) string {
+	if !strings.Contains(addr, ":") {
+		addr = ":" + addr
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "127.0.0.1" + addr
+	}
+
+	return addr
+}
+
+// serve starts serving the files in dir over HTTP at addr, with a live reload
+// client injected into HTML pages
+// An address without a host is only served on localhost
+func serve(addr, dir string) error {
+	ln, err := net.Listen("tcp", listenAddr(addr))
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(reloadPath, serveReload)
+	mux.Handle("/", &pageServer{dir: dir, files: http.FileServer(http.Dir(dir))})
+
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			logf(levelError, "watch serve error: %v", err)
+		}
+	}()
+
+	logf(levelDebug, "watch: serving %v at http://%v", dir, ln.Addr())
+
+	return nil
+}
+
+// serveReload sends a server-sent "reload" event after every successful run
+// until the page goes away
+func serveReload(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+
+		return
+	}
+
+	events, unsubscribe := subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case e := <-events:
+			if e.Event != "finished" || e.Status != "ok" {
+				continue
+			}
+
+			if _, err := fmt.Fprint(w, "event: reload\ndata: {}\n\n"); err != nil {
+				return
+			}
+
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// pageServer serves files like http.FileServer but injects the reload client
+// into HTML pages
+type pageServer struct {
+	dir   string
+	files http.Handler
+}
+
+func (s *pageServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	urlPath := path.Clean("/" + r.URL.Path)
+	name := filepath.Join(s.dir, filepath.FromSlash(urlPath))
+
+	// Directories are only served as their index page once the file server
+	// would have redirected to the path with a trailing slash
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = filepath.Join(name, "index.html")
+	}
+
+	if ext := filepath.Ext(name); ext != ".html" && ext != ".htm" {
+		s.files.ServeHTTP(w, r)
+
+		return
+	}
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		s.files.ServeHTTP(w, r)
+
+		return
+	}
+
+	// The client goes before the closing body tag where there is one,
+	// otherwise at the end of the page
+	if i := bytes.LastIndex(bytes.ToLower(b), []byte("</body>")); i >= 0 {
+		b = append(b[:i:i], append([]byte(reloadClient), b[i:]...)...)
+	} else {
+		b = append(b, reloadClient...)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(b)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// simulation is the outcome of pretending that a list of files changed
+type simulation struct {
+	Files    []string   `json:"files"`
+	Watched  []string   `json:"watched"`
+	Run      bool       `json:"run"`
+	Reason   string     `json:"reason,omitempty"`
+	OnChange string     `json:"onChange,omitempty"`
+	Summary  string     `json:"summary,omitempty"`
+	Commands []planStep `json:"commands"`
+}
+
+// simulate works out what a change to the given files would do, going through
+// the same filtering and run decisions as a real change
+// Files that exist count as modified and the rest as deleted, for -on
+// The on-change script isn't run here, so its decision is left to the caller
+func simulate(paths []string, walk func(fs.WalkDirFunc) error, skip func(string, fs.DirEntry) bool) simulation {
+	s := simulation{
+		Files:    []string{},
+		Watched:  []string{},
+		OnChange: opts.onChange,
+		Summary:  opts.summaryCmd,
+		Commands: []planStep{},
+	}
+
+	var watched, changed []string
+	for _, path := range paths {
+		path = filepath.Clean(path)
+
+		s.Files = append(s.Files, filepath.ToSlash(path))
+
+		if skipped(path, skip) {
+			continue
+		}
+
+		watched = append(watched, path)
+		s.Watched = append(s.Watched, filepath.ToSlash(path))
+
+		kind := onModify
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			kind = onDelete
+		}
+
+		if counts(kind) {
+			changed = append(changed, path)
+		}
+	}
+
+	switch {
+	case len(watched) == 0:
+		s.Reason = "none of the files are watched"
+
+	case len(changed) == 0:
+		s.Reason = "none of the changes are in -on"
+
+	case len(changed) < opts.minFilesChanged:
+		s.Reason = fmt.Sprintf("fewer than %v files changed", opts.minFilesChanged)
+
+	case !simulatedGroupsAllow(changed, walk, skip):
+		s.Reason = "not every file in a -require-all group changed"
+
+	default:
+		s.Commands = planSteps(changed)
+		s.Run = len(s.Commands) > 0
+
+		if !s.Run {
+			s.Reason = "no commands are affected by the files"
+		}
+	}
+
+	return s
+}
+
+// simulatedGroupsAllow reports whether the changed files would run, given the
+// -require-all groups
+// As in the main loop, a change outside of every group or a deletion runs
+// straight away, and changes in a group only run once every file in the group
+// has changed
+func simulatedGroupsAllow(changed []string, walk func(fs.WalkDirFunc) error, skip func(string, fs.DirEntry) bool) bool {
+	groups := newRequireGroups(opts.requireAll)
+	if len(groups) == 0 {
+		return true
+	}
+
+	files, err := scan(walk, skip)
+	if err != nil {
+		logf(levelError, "watch scan error: %v", err)
+	}
+
+	for _, path := range changed {
+		if _, ok := files[path]; !ok {
+			return true
+		}
+
+		inGroup := slices.ContainsFunc(groups, func(group *requireGroup) bool {
+			return group.match(filepath.ToSlash(path))
+		})
+		if !inGroup {
+			return true
+		}
+	}
+
+	for _, group := range groups {
+		var members int
+		complete := true
+		for path := range files {
+			if !group.match(filepath.ToSlash(path)) {
+				continue
+			}
+
+			members++
+			if !slices.Contains(changed, path) {
+				complete = false
+			}
+		}
+
+		if members > 0 && complete {
+			return true
+		}
+	}
+
+	return false
+}
+
+// changedFiles returns the watched files from the simulation as paths
+func (s simulation) changedFiles() []string {
+	files := make([]string, len(s.Watched))
+	for i, path := range s.Watched {
+		files[i] = filepath.FromSlash(path)
+	}
+
+	return files
+}
+
+func (s simulation) print(w io.Writer, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(s)
+	}
+
+	fmt.Fprintf(w, "changed: %v\n", strings.Join(s.Files, " "))
+	fmt.Fprintf(w, "watched: %v\n", strings.Join(s.Watched, " "))
+
+	if !s.Run {
+		fmt.Fprintf(w, "nothing would run: %v\n", s.Reason)
+
+		return nil
+	}
+
+	if s.OnChange != "" {
+		fmt.Fprintf(w, "on-change: %v\n", s.OnChange)
+	}
+	if s.Summary != "" {
+		fmt.Fprintf(w, "summary: %v\n", s.Summary)
+	}
+
+	printSteps(w, s.Commands)
+
+	return nil
+}
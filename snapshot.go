@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// snapshot is the on disk form of the watched files and their modification
+// times, with paths stored using forward slashes
+type snapshot struct {
+	Files map[string]time.Time `json:"files"`
+}
+
+// scan does a single pass over the tree and returns the modification times of
+// all of the files that aren't skipped
+// Directories are left out, since their modification times change whenever
+// a file is added or removed and would only add noise to a diff
+// A path that can't be read is reported and left out rather than stopping the
+// scan, as it is in the main loop
+func scan(walk func(fs.WalkDirFunc) error, skip func(string, fs.DirEntry) bool) (map[string]time.Time, error) {
+	files := make(map[string]time.Time)
+	err := walk(func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == "." {
+				return err
+			}
+
+			if !errors.Is(err, fs.ErrNotExist) {
+				logf(levelInfo, "watch: can't read %v, skipping it: %v", path, err)
+			}
+
+			if entry != nil && entry.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if skip(path, entry) {
+			if entry.IsDir() && path != "." {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		fi, err := info(path, entry)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				logf(levelInfo, "watch: can't read %v, skipping it: %v", path, err)
+			}
+
+			return nil
+		}
+
+		files[path] = fi.ModTime()
+
+		return nil
+	})
+
+	return files, err
+}
+
+func writeSnapshot(name string, files map[string]time.Time) error {
+	snap := snapshot{Files: make(map[string]time.Time, len(files))}
+	for path, modified := range files {
+		snap.Files[filepath.ToSlash(path)] = modified
+	}
+
+	b, err := json.MarshalIndent(snap, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(name, append(b, '\n'), 0o644)
+}
+
+func readSnapshot(name string) (map[string]time.Time, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]time.Time, len(snap.Files))
+	for path, modified := range snap.Files {
+		files[filepath.FromSlash(path)] = modified
+	}
+
+	return files, nil
+}
+
+// snapshotDiff lists the paths that differ between a snapshot and the tree
+type snapshotDiff struct {
+	Added    []string `json:"added"`
+	Modified []string `json:"modified"`
+	Deleted  []string `json:"deleted"`
+}
+
+func diffSnapshot(before, after map[string]time.Time) snapshotDiff {
+	diff := snapshotDiff{
+		Added:    []string{},
+		Modified: []string{},
+		Deleted:  []string{},
+	}
+
+	for path, modified := range after {
+		if prev, ok := before[path]; !ok {
+			diff.Added = append(diff.Added, path)
+		} else if !prev.Equal(modified) {
+			diff.Modified = append(diff.Modified, path)
+		}
+	}
+
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			diff.Deleted = append(diff.Deleted, path)
+		}
+	}
+
+	slices.Sort(diff.Added)
+	slices.Sort(diff.Modified)
+	slices.Sort(diff.Deleted)
+
+	return diff
+}
+
+func (d snapshotDiff) print(w io.Writer, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(d)
+	}
+
+	for _, path := range d.Added {
+		fmt.Fprintf(w, "added    %v\n", path)
+	}
+	for _, path := range d.Modified {
+		fmt.Fprintf(w, "modified %v\n", path)
+	}
+	for _, path := range d.Deleted {
+		fmt.Fprintf(w, "deleted  %v\n", path)
+	}
+
+	return nil
+}
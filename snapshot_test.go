@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	for _, path := range []string{"main.go", "pkg/lib.go", "bad/ok.go"} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		edit(t, path)
+	}
+
+	// The walk reports bad as unreadable, so nothing under it is scanned
+	walk := func(fn fs.WalkDirFunc) error {
+		return filepath.WalkDir(".", func(path string, entry fs.DirEntry, err error) error {
+			if path == "bad" {
+				return fn(path, entry, errors.New("permission denied"))
+			}
+
+			return fn(path, entry, err)
+		})
+	}
+	skip := func(string, fs.DirEntry) bool { return false }
+
+	files, err := scan(walk, skip)
+	if err != nil {
+		t.Fatalf("an unreadable directory stopped the scan: %v", err)
+	}
+
+	got := slices.Sorted(maps.Keys(files))
+	want := []string{"main.go", filepath.Join("pkg", "lib.go")}
+	if !slices.Equal(got, want) {
+		t.Errorf("scanned %q, want %q", got, want)
+	}
+}
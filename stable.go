@@ -0,0 +1,31 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"time"
+)
+
+// waitForStable re-stats a changed file until its size and modification time
+// are the same across two consecutive checks, which indicates that whatever
+// was writing to it has finished
+// It returns false if the file didn't settle within the configured number
+// of attempts, or if it disappeared while waiting
+func waitForStable(path string, fi fs.FileInfo) (fs.FileInfo, bool) {
+	for range opts.stableAttempts {
+		time.Sleep(opts.stableInterval)
+
+		next, err := os.Stat(path)
+		if err != nil {
+			return nil, false
+		}
+
+		if next.Size() == fi.Size() && next.ModTime().Equal(fi.ModTime()) {
+			return next, true
+		}
+
+		fi = next
+	}
+
+	return nil, false
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// setStableOpts sets how waitForStable checks files for the rest of the test
+func setStableOpts(t *testing.T, interval time.Duration, attempts int) {
+	prevInterval, prevAttempts := opts.stableInterval, opts.stableAttempts
+	t.Cleanup(func() {
+		opts.stableInterval, opts.stableAttempts = prevInterval, prevAttempts
+	})
+
+	opts.stableInterval, opts.stableAttempts = interval, attempts
+}
+
+// grow appends to the file every few milliseconds until stop is closed
+func grow(t *testing.T, path string, stop chan struct{}) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Error(err)
+
+			return
+		}
+		defer f.Close()
+
+		for {
+			select {
+			case <-stop:
+				return
+
+			case <-time.After(5 * time.Millisecond):
+				f.WriteString("more\n")
+			}
+		}
+	}()
+
+	return done
+}
+
+func TestWaitForStableGrowingFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	setStableOpts(t, 30*time.Millisecond, 50)
+
+	if err := os.WriteFile("big.log", []byte("start\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat("big.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The writer finishes part way through the checks
+	stop := make(chan struct{})
+	done := grow(t, "big.log", stop)
+	time.AfterFunc(200*time.Millisecond, func() { close(stop) })
+
+	stable, ok := waitForStable("big.log", fi)
+	<-done
+	if !ok {
+		t.Fatal("waitForStable gave up on a file that stopped growing")
+	}
+
+	final, err := os.Stat("big.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stable.Size() != final.Size() {
+		t.Errorf("waitForStable returned a size of %v, want the final size of %v", stable.Size(), final.Size())
+	}
+	if stable.Size() == fi.Size() {
+		t.Error("waitForStable returned the size from before the file grew")
+	}
+}
+
+func TestWaitForStableStillGrowing(t *testing.T) {
+	t.Chdir(t.TempDir())
+	setStableOpts(t, 20*time.Millisecond, 5)
+
+	if err := os.WriteFile("big.log", []byte("start\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat("big.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	done := grow(t, "big.log", stop)
+
+	_, ok := waitForStable("big.log", fi)
+	close(stop)
+	<-done
+
+	if ok {
+		t.Error("waitForStable reported a file that never stopped growing as stable")
+	}
+}
+
+func TestWaitForStableDeleted(t *testing.T) {
+	t.Chdir(t.TempDir())
+	setStableOpts(t, 10*time.Millisecond, 5)
+
+	if err := os.WriteFile("tmp.txt", []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat("tmp.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove("tmp.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := waitForStable("tmp.txt", fi); ok {
+		t.Error("waitForStable reported a deleted file as stable")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// result describes the outcome of a single run of the command chain
+// Its exported fields are what the -format template can refer to
+type result struct {
+	Trigger  string        `json:"trigger"`
+	Files    []string      `json:"files"`
+	Commands []string      `json:"commands"`
+	Status   string        `json:"status,omitempty"`
+	ExitCode int           `json:"exitCode"`
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+const resultFields = ".Trigger .Files .Commands .Status .ExitCode .Duration"
+
+var statusFormat *template.Template
+
+// state is the part of the main loop's state that can be queried from other
+// goroutines, such as those serving the control socket
+var state struct {
+	sync.Mutex
+	paused  bool
+	files   int
+	lastRun time.Time
+}
+
+// parseFormat compiles the -format template and executes it once against an
+// empty result so that references to unknown fields are caught at startup
+func parseFormat(text string) (*template.Template, error) {
+	t, err := template.New("format").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.Execute(io.Discard, result{}); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// reportMu serialises reports, which come from the goroutines waiting on
+// commands, and guards lastStatus and lastExitCode
+var reportMu sync.Mutex
+
+// lastStatus is the status of the most recent run that either passed or
+// failed, used to detect when a build breaks or recovers
+var lastStatus string
+
+// lastExitCode is the exit code of the most recent run with a status, which
+// is the exit code of its last failed command or 0 if it passed
+var lastExitCode int
+
+// report is called once a run has finished
+func report(res result) {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+
+	var edge string
+	switch {
+	case res.Status == "failed" && lastStatus != "failed":
+		edge = edgeBreak
+	case res.Status == "ok" && lastStatus == "failed":
+		edge = edgeRecover
+	}
+
+	if res.Status == "ok" || res.Status == "failed" {
+		lastStatus = res.Status
+		lastExitCode = res.ExitCode
+	}
+
+	publish(event{Event: "finished", Time: time.Now(), result: res})
+
+	if statusFormat != nil {
+		var sb strings.Builder
+		if err := statusFormat.Execute(&sb, res); err != nil {
+			logf(levelError, "watch format error: %v", err)
+		} else {
+			logf(levelInfo, "%v", strings.TrimRight(sb.String(), "\n"))
+		}
+	}
+
+	notify(res, edge)
+}
+
+// nextScan is printed in -json mode before waiting for the next scan
+type nextScan struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	Next  time.Time `json:"next"`
+	Files int       `json:"files"`
+}
+
+// announceNextScan prints when the next scan will happen
+func announceNextScan(interval time.Duration, files int) {
+	if opts.json {
+		t := time.Now()
+
+		json.NewEncoder(logOut).Encode(nextScan{
+			Event: "next-scan",
+			Time:  t,
+			Next:  t.Add(interval),
+			Files: files,
+		})
+
+		return
+	}
+
+	logf(levelDebug, "watch: next scan in %v (watching %v files)", interval, files)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// stopSignals are the signals that -signal accepts, by name
+var stopSignals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// stopSignal is the signal that running commands are sent to stop them
+// Anything other than SIGKILL gives them until -kill-timeout to exit before
+// they're killed
+var stopSignal = syscall.SIGKILL
+
+// reloadSignal is the signal that commands still running when a change comes
+// in are sent instead of being restarted, if any
+// They're stopped with SIGTERM when watch exits
+var reloadSignal syscall.Signal
+
+// parseSignal parses a signal name such as SIGINT, with or without the SIG
+// prefix and in any case
+func parseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	sig, ok := stopSignals[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %q, expected SIGHUP, SIGINT, SIGQUIT, SIGKILL, or SIGTERM", name)
+	}
+
+	return sig, nil
+}
+
+// setStopSignal sets the signal used to stop commands from -signal, or from
+// -sigterm, which is the same as -signal SIGTERM
+func setStopSignal() error {
+	switch {
+	case opts.signal != "":
+		sig, err := parseSignal(opts.signal)
+		if err != nil {
+			return err
+		}
+
+		stopSignal = sig
+
+	case opts.sigterm:
+		stopSignal = syscall.SIGTERM
+	}
+
+	// SIGHUP asks programs to reload rather than exit, so sending it and then
+	// killing them when they carry on running would only restart them anyway
+	// Windows can't send it, so commands are still restarted there
+	if stopSignal == syscall.SIGHUP && runtime.GOOS != "windows" {
+		reloadSignal = syscall.SIGHUP
+		stopSignal = syscall.SIGTERM
+	}
+
+	// Windows can only ask a process tree to exit with taskkill, which is
+	// what's used for any signal other than SIGKILL
+	if runtime.GOOS == "windows" && stopSignal != syscall.SIGKILL && stopSignal != syscall.SIGTERM {
+		logf(levelInfo, "watch: %v isn't supported on windows, commands will be asked to exit with taskkill", opts.signal)
+	}
+
+	return nil
+}
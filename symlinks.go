@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// walkDir walks the tree at root like filepath.WalkDir, following symlinks to
+// directories if -follow-symlinks is set
+func walkDir(root string, fn fs.WalkDirFunc) error {
+	if !opts.followSymlinks {
+		return filepath.WalkDir(root, fn)
+	}
+
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+
+	real, err = filepath.Abs(real)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+
+	l := &linkWalker{root: real, visited: map[string]bool{real: true}}
+
+	return l.walk(root, fn)
+}
+
+// linkWalker follows symlinks to directories, which are walked under the
+// path of the link so that skip patterns and the paths given to commands
+// are the same as if the files were really there
+type linkWalker struct {
+	// root is the real path of the directory being walked
+	root string
+
+	// visited holds the real paths of the directories that links have led
+	// to, so that each one is only walked once and cyclic links end
+	visited map[string]bool
+}
+
+func (l *linkWalker) walk(root string, fn fs.WalkDirFunc) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.Type()&fs.ModeSymlink == 0 {
+			return fn(path, entry, err)
+		}
+
+		fi, statErr := os.Stat(path)
+		if statErr != nil || !fi.IsDir() {
+			return fn(path, entry, err)
+		}
+
+		if !l.enter(path) {
+			return nil
+		}
+
+		if err := fn(path, fs.FileInfoToDirEntry(fi), nil); err != nil {
+			if err == filepath.SkipDir {
+				return nil
+			}
+
+			return err
+		}
+
+		// Walking from "link/." makes the walk start from the directory
+		// the link points to, and the paths under it come out as
+		// "link/name" since they're cleaned
+		start := path + string(filepath.Separator) + "."
+
+		return l.walk(start, func(p string, entry fs.DirEntry, err error) error {
+			if p == start {
+				// The link was already passed to fn as a directory
+				if err != nil {
+					return fn(path, entry, err)
+				}
+
+				return nil
+			}
+
+			return fn(p, entry, err)
+		})
+	})
+}
+
+// enter reports whether the directory a link points to should be walked,
+// which it shouldn't be if it has already been walked or if it contains the
+// root, since walking it would walk the root again
+func (l *linkWalker) enter(path string) bool {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+
+	real, err = filepath.Abs(real)
+	if err != nil {
+		return false
+	}
+
+	if l.visited[real] || strings.HasPrefix(l.root, real+string(filepath.Separator)) {
+		return false
+	}
+
+	l.visited[real] = true
+
+	return true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// teeBuffer is the number of writes that can be queued for the tee target
+// before further output is dropped
+const teeBuffer = 256
+
+// teeWriter mirrors command output to a named pipe or unix socket
+//
+// Writes are queued and copied to the target by a separate goroutine so that
+// a slow or missing consumer never blocks the commands
+// Output is delivered in the order it was written, but if the queue is full,
+// or nothing is reading from the target, the output is dropped rather than
+// buffered
+type teeWriter struct {
+	path        string
+	queue       chan []byte
+	target      io.WriteCloser
+	lastAttempt time.Time
+}
+
+func newTeeWriter(path string) *teeWriter {
+	t := &teeWriter{
+		path:  path,
+		queue: make(chan []byte, teeBuffer),
+	}
+
+	go t.loop()
+
+	return t
+}
+
+// Write never fails so that it can be used in an io.MultiWriter alongside
+// the terminal without affecting it
+func (t *teeWriter) Write(p []byte) (int, error) {
+	select {
+	case t.queue <- append([]byte(nil), p...):
+	default:
+	}
+
+	return len(p), nil
+}
+
+func (t *teeWriter) loop() {
+	for b := range t.queue {
+		if t.target == nil && !t.connect() {
+			continue
+		}
+
+		if _, err := t.target.Write(b); err != nil {
+			t.target.Close()
+			t.target = nil
+		}
+	}
+}
+
+// connect opens the target, waiting at least a second between attempts so
+// that an absent consumer doesn't cost a failed open for every write
+func (t *teeWriter) connect() bool {
+	if time.Since(t.lastAttempt) < time.Second {
+		return false
+	}
+
+	t.lastAttempt = time.Now()
+
+	if fi, err := os.Stat(t.path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		conn, err := net.Dial("unix", t.path)
+		if err != nil {
+			return false
+		}
+
+		t.target = conn
+
+		return true
+	}
+
+	// Opening a named pipe without blocking fails when there's no reader
+	f, err := os.OpenFile(t.path, os.O_WRONLY|os.O_APPEND|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return false
+	}
+
+	t.target = f
+
+	return true
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// triggerFile forces a run whenever it's created or touched, whether or not
+// it would be watched, so that touching it works as a rebuild button
+type triggerFile struct {
+	path    string
+	modTime time.Time
+	polled  bool
+}
+
+// poll reports whether the file was created or modified since the last poll
+// The first poll only records its state
+func (t *triggerFile) poll() bool {
+	var modTime time.Time
+	if fi, err := os.Stat(t.path); err == nil {
+		modTime = fi.ModTime()
+	}
+
+	touched := t.polled && !modTime.IsZero() && !modTime.Equal(t.modTime)
+	t.modTime = modTime
+	t.polled = true
+
+	return touched
+}